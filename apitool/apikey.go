@@ -0,0 +1,245 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+var (
+	// E401APIKey indicates the API key is missing, invalid or expired
+	E401APIKey = jsonapi.E401.SetData("missing or invalid API key")
+	// E403APIKey indicates the API key does not have required scopes
+	E403APIKey = jsonapi.E403.SetData("insufficient scope")
+)
+
+// APIKey is the information of an API key
+type APIKey struct {
+	// public identifier of the key, which is safe to be logged
+	ID     string
+	Owner  string
+	Scopes []string
+	// rate limit tier of the key, see APIKeyRateLimit
+	RateLimit *RateLimit
+	// never expires if zero
+	ExpiresAt time.Time
+}
+
+// HasScope reports whether k has all the scopes
+func (k *APIKey) HasScope(scopes ...string) bool {
+	for _, s := range scopes {
+		found := false
+		for _, x := range k.Scopes {
+			if x == s {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// HashAPIKey hashes the key with SHA-256, keys are saved and looked up by
+// hash, so leaking the store does not leak the keys
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyStore saves API keys by hash, see HashAPIKey
+//
+// Implement it to save keys in database.
+type APIKeyStore interface {
+	// Lookup finds the key by hash, returns nil if not found
+	Lookup(ctx context.Context, hash string) (*APIKey, error)
+	// Touch updates last-used time of the key
+	Touch(ctx context.Context, hash string, t time.Time) error
+}
+
+type memAPIKey struct {
+	key      APIKey
+	lastUsed time.Time
+}
+
+// MemoryAPIKeyStore is an in-memory APIKeyStore, mostly for testing and
+// small set of static keys. Zero value is ready to use.
+type MemoryAPIKeyStore struct {
+	lock sync.Mutex
+	keys map[string]*memAPIKey
+}
+
+// Add saves the key, key is the plain text API key
+func (s *MemoryAPIKeyStore) Add(key string, info APIKey) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.keys == nil {
+		s.keys = map[string]*memAPIKey{}
+	}
+	s.keys[HashAPIKey(key)] = &memAPIKey{key: info}
+}
+
+// LastUsed returns last-used time of the key, key is the plain text API key
+func (s *MemoryAPIKeyStore) LastUsed(key string) time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if k, ok := s.keys[HashAPIKey(key)]; ok {
+		return k.lastUsed
+	}
+	return time.Time{}
+}
+
+// Lookup implements APIKeyStore
+func (s *MemoryAPIKeyStore) Lookup(_ context.Context, hash string) (*APIKey, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	k, ok := s.keys[hash]
+	if !ok {
+		return nil, nil
+	}
+	ret := k.key
+	return &ret, nil
+}
+
+// Touch implements APIKeyStore
+func (s *MemoryAPIKeyStore) Touch(_ context.Context, hash string, t time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if k, ok := s.keys[hash]; ok {
+		k.lastUsed = t
+	}
+	return nil
+}
+
+type apiKeyKey struct{}
+
+// APIKeyFrom retrieves the key saved by APIKeyAuth
+func APIKeyFrom(ctx context.Context) (*APIKey, bool) {
+	ret, ok := ctx.Value(apiKeyKey{}).(*APIKey)
+	return ret, ok
+}
+
+// APIKeyRateLimit returns the rate limit tier of the key, it is designed to be
+// used as RateLimiter.LimitOf
+//
+//	jsonapi.With(apitool.APIKeyAuth{
+//	    Store: store,
+//	}.Middleware).With(apitool.RateLimiter{
+//	    Limit:   apitool.PerMinute(60),
+//	    LimitOf: apitool.APIKeyRateLimit,
+//	    Key:     apitool.RateLimitByAPIKey,
+//	}.Middleware).Register(mux, apis)
+func APIKeyRateLimit(r jsonapi.Request) (RateLimit, bool) {
+	k, ok := APIKeyFrom(r.R().Context())
+	if !ok || k.RateLimit == nil {
+		return RateLimit{}, false
+	}
+	return *k.RateLimit, true
+}
+
+// RateLimitByAPIKey identifies client by ID of the key, falls back to
+// RateLimitByIP if not authenticated by APIKeyAuth
+func RateLimitByAPIKey(r jsonapi.Request) string {
+	if k, ok := APIKeyFrom(r.R().Context()); ok {
+		return "key:" + k.ID
+	}
+	return RateLimitByIP(r)
+}
+
+// APIKeyAuth is a middleware authenticates clients with static API keys
+//
+//	jsonapi.With(apitool.APIKeyAuth{
+//	    Store:  store,
+//	    Scopes: []string{"orders:read"},
+//	}.Middleware).Register(mux, apis)
+//
+//	func myHandler(q jsonapi.Request) (interface{}, error) {
+//	    key, _ := apitool.APIKeyFrom(q.R().Context())
+//	    log.Printf("called by %s", key.Owner)
+//	    ...
+//	}
+//
+// Missing, unknown or expired keys are rejected with E401APIKey, keys without
+// required scopes are rejected with E403APIKey.
+type APIKeyAuth struct {
+	// REQUIRED
+	Store APIKeyStore
+	// header to read the key from, defaults to X-API-Key
+	Header string
+	// query parameter to read the key from if the header is absent, disabled
+	// if empty. Keys in url might be logged by proxies, use it carefully.
+	Query string
+	// required scopes, the key must have all of them
+	Scopes []string
+	// minimal interval to update last-used time of a key, defaults to 1 minute
+	TouchInterval time.Duration
+}
+
+// Middleware is the *real* middleware part of APIKeyAuth
+func (a APIKeyAuth) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	// safe to set struct member as it is passed by value
+	if a.Header == "" {
+		a.Header = "X-API-Key"
+	}
+	if a.TouchInterval <= 0 {
+		a.TouchInterval = time.Minute
+	}
+
+	var lock sync.Mutex
+	touched := map[string]time.Time{}
+	touch := func(ctx context.Context, hash string, now time.Time) {
+		lock.Lock()
+		if now.Sub(touched[hash]) < a.TouchInterval {
+			lock.Unlock()
+			return
+		}
+		touched[hash] = now
+		if len(touched) > 4096 {
+			for k, t := range touched {
+				if now.Sub(t) >= a.TouchInterval {
+					delete(touched, k)
+				}
+			}
+		}
+		lock.Unlock()
+		// failing to track usage should not fail the request
+		a.Store.Touch(ctx, hash, now)
+	}
+
+	return func(r jsonapi.Request) (interface{}, error) {
+		req := r.R()
+		key := req.Header.Get(a.Header)
+		if key == "" && a.Query != "" {
+			key = req.URL.Query().Get(a.Query)
+		}
+		if key == "" {
+			return nil, E401APIKey
+		}
+
+		hash := HashAPIKey(key)
+		info, err := a.Store.Lookup(req.Context(), hash)
+		if err != nil {
+			return nil, jsonapi.E500.SetOrigin(err).SetData("failed to lookup API key")
+		}
+		now := time.Now()
+		if info == nil || (!info.ExpiresAt.IsZero() && now.After(info.ExpiresAt)) {
+			return nil, E401APIKey
+		}
+		touch(req.Context(), hash, now)
+
+		if !info.HasScope(a.Scopes...) {
+			return nil, E403APIKey
+		}
+		return h(r.WithValue(apiKeyKey{}, info))
+	}
+}
@@ -0,0 +1,90 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	store := &MemoryAPIKeyStore{}
+	store.Add("key-a", APIKey{ID: "a", Owner: "alice", Scopes: []string{"read", "write"}})
+	store.Add("key-b", APIKey{ID: "b", Owner: "bob", Scopes: []string{"read"},
+		RateLimit: &RateLimit{Rate: 1, Period: time.Hour}})
+	store.Add("key-c", APIKey{ID: "c", Owner: "carol", Scopes: []string{"write"},
+		ExpiresAt: time.Now().Add(-time.Second)})
+
+	h := jsonapi.With(APIKeyAuth{
+		Store:  store,
+		Query:  "api_key",
+		Scopes: []string{"read"},
+	}.Middleware).With(RateLimiter{
+		Limit:   PerSecond(100),
+		LimitOf: APIKeyRateLimit,
+		Key:     RateLimitByAPIKey,
+	}.Middleware)
+	mux := http.NewServeMux()
+	h.Register(mux, []jsonapi.API{{Pattern: "/", Handler: func(r jsonapi.Request) (interface{}, error) {
+		k, _ := APIKeyFrom(r.R().Context())
+		return k.Owner, nil
+	}}})
+
+	cases := []struct {
+		name   string
+		uri    string
+		header string
+		code   int
+		limit  string
+	}{
+		{"header", "/", "key-a", 200, "100"},
+		{"query", "/?api_key=key-a", "", 200, "100"},
+		{"missing", "/", "", 401, ""},
+		{"unknown", "/", "key-x", 401, ""},
+		{"expired", "/", "key-c", 401, ""},
+		{"tier", "/", "key-b", 200, "1"},
+		{"tier-exceeded", "/", "key-b", 429, "1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", c.uri, nil)
+			if c.header != "" {
+				r.Header.Set("X-API-Key", c.header)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != c.code {
+				t.Fatalf("expected %d, got %d: %s", c.code, w.Code, w.Body.String())
+			}
+			if x := w.Header().Get("RateLimit-Limit"); x != c.limit {
+				t.Errorf("expected limit %s, got %s", c.limit, x)
+			}
+		})
+	}
+
+	if store.LastUsed("key-a").IsZero() || !store.LastUsed("key-c").IsZero() {
+		t.Error("unexpected last-used time")
+	}
+
+	t.Run("scope", func(t *testing.T) {
+		h := jsonapi.Handler(APIKeyAuth{Store: store, Scopes: []string{"read", "write"}}.Middleware(
+			func(r jsonapi.Request) (interface{}, error) { return nil, nil },
+		))
+		for key, code := range map[string]int{"key-a": 200, "key-b": 403} {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("X-API-Key", key)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != code {
+				t.Errorf("expected %d for %s, got %d", code, key, w.Code)
+			}
+		}
+	})
+}
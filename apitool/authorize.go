@@ -0,0 +1,95 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"github.com/raohwork/jsonapi"
+)
+
+var (
+	// E401Policy indicates the API requires authentication
+	E401Policy = jsonapi.E401.SetData("authentication required")
+	// E403Policy indicates the client is not allowed to access the API
+	E403Policy = jsonapi.E403.SetData("permission denied")
+)
+
+// StaticPrincipal is a jsonapi.Principal with fixed roles and scopes, which
+// is handy to convert your claims or session into a principal
+type StaticPrincipal struct {
+	Roles  []string
+	Scopes []string
+}
+
+func contains(arr []string, v string) bool {
+	for _, x := range arr {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole implements jsonapi.Principal
+func (p StaticPrincipal) HasRole(role string) bool { return contains(p.Roles, role) }
+
+// HasScope implements jsonapi.Principal
+func (p StaticPrincipal) HasScope(scope string) bool { return contains(p.Scopes, scope) }
+
+// PrincipalFromAPIKey converts key saved by APIKeyAuth into a principal
+// without any role. It is designed to be used as Authorizer.Principal.
+func PrincipalFromAPIKey(r jsonapi.Request) jsonapi.Principal {
+	k, ok := APIKeyFrom(r.R().Context())
+	if !ok {
+		return nil
+	}
+	return StaticPrincipal{Scopes: k.Scopes}
+}
+
+// Authorizer is a middleware enforces jsonapi.Policy declared by
+// jsonapi.PolicyMux or jsonapi.PolicySet. It must be placed after
+// authentication middleware.
+//
+//	jsonapi.With(apitool.APIKeyAuth{
+//	    Store: store,
+//	}.Middleware).With(apitool.Authorizer{
+//	    Principal:      apitool.PrincipalFromAPIKey,
+//	    DenyUndeclared: true,
+//	}.Middleware).Register(jsonapi.PolicyMux(reg, policies), apis)
+//
+// Unauthenticated clients are rejected with E401Policy, and others are
+// rejected with E403Policy. Errors returned by Policy.Rule are saved as origin
+// of E403Policy.
+type Authorizer struct {
+	// REQUIRED, returns nil if not authenticated
+	Principal func(r jsonapi.Request) jsonapi.Principal
+	// rejects APIs without policy with E403Policy, recommended
+	DenyUndeclared bool
+}
+
+// Middleware is the *real* middleware part of Authorizer
+func (a Authorizer) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	return func(r jsonapi.Request) (interface{}, error) {
+		policy := jsonapi.PolicyOf(r.R())
+		if policy == nil {
+			if a.DenyUndeclared {
+				return nil, E403Policy
+			}
+			return h(r)
+		}
+
+		who := a.Principal(r)
+		ok, err := policy.Allow(who, r)
+		if ok {
+			return h(r)
+		}
+		if who == nil {
+			return nil, E401Policy
+		}
+		if err != nil {
+			return nil, E403Policy.SetOrigin(err)
+		}
+		return nil, E403Policy
+	}
+}
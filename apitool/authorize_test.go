@@ -0,0 +1,80 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestAuthorizer(t *testing.T) {
+	h := func(r jsonapi.Request) (interface{}, error) { return "ok", nil }
+	owner := &jsonapi.Policy{
+		Roles: []string{"user"},
+		Rule: func(p jsonapi.Principal, r jsonapi.Request) error {
+			if r.R().URL.Query().Get("owner") != "me" {
+				return errors.New("not owner")
+			}
+			return nil
+		},
+	}
+
+	reg := jsonapi.NewRegistry(nil)
+	jsonapi.With(Authorizer{
+		Principal: func(r jsonapi.Request) jsonapi.Principal {
+			if r.R().Header.Get("X-User") == "" {
+				return nil
+			}
+			return StaticPrincipal{
+				Roles:  []string{r.R().Header.Get("X-User")},
+				Scopes: []string{"read"},
+			}
+		},
+		DenyUndeclared: true,
+	}.Middleware).Register(jsonapi.PolicyMux(reg, map[string]*jsonapi.Policy{
+		"/public": {Public: true},
+		"/admin":  {Roles: []string{"admin"}},
+		"/read":   {Scopes: []string{"read"}},
+		"/owner":  owner,
+	}), []jsonapi.API{
+		{Pattern: "/public", Handler: h},
+		{Pattern: "/admin", Handler: h},
+		{Pattern: "/read", Handler: h},
+		{Pattern: "/owner", Handler: h},
+		{Pattern: "/undeclared", Handler: h},
+	})
+
+	cases := []struct {
+		uri, user string
+		code      int
+	}{
+		{"/public", "", 200},
+		{"/admin", "", 401},
+		{"/admin", "user", 403},
+		{"/admin", "admin", 200},
+		{"/read", "user", 200},
+		{"/owner?owner=me", "user", 200},
+		{"/owner?owner=you", "user", 403},
+		{"/owner?owner=me", "admin", 403},
+		{"/undeclared", "admin", 403},
+	}
+
+	for _, c := range cases {
+		t.Run(c.uri+"#"+c.user, func(t *testing.T) {
+			r := httptest.NewRequest("GET", c.uri, nil)
+			if c.user != "" {
+				r.Header.Set("X-User", c.user)
+			}
+			w := httptest.NewRecorder()
+			reg.ServeHTTP(w, r)
+			if w.Code != c.code {
+				t.Fatalf("expected %d, got %d: %s", c.code, w.Code, w.Body.String())
+			}
+		})
+	}
+}
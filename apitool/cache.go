@@ -0,0 +1,446 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// CacheEntry is a cached response
+type CacheEntry struct {
+	// encoded data returned by handler
+	Data json.RawMessage
+	// true if handler returns a jsonapi.Document
+	Document bool
+	// response headers set by handler, excluding Set-Cookie, hop-by-hop and
+	// per-request headers
+	Header http.Header
+	Tags   []string
+	// the entry is fresh before Fresh, and can be served while revalidating
+	// before Stale
+	Fresh time.Time
+	Stale time.Time
+}
+
+// CacheStore saves cached responses
+type CacheStore interface {
+	Get(key string) (CacheEntry, bool)
+	Set(key string, e CacheEntry)
+	// Delete removes entries by keys
+	Delete(keys ...string)
+	// DeleteTag removes entries having any of the tags
+	DeleteTag(tags ...string)
+}
+
+type lruItem struct {
+	key   string
+	entry CacheEntry
+}
+
+// LRUCacheStore is an in-memory CacheStore which keeps at most Size entries,
+// the least recently used entry is removed first. Zero value is ready to use.
+type LRUCacheStore struct {
+	// max number of entries, defaults to 1000
+	Size int
+
+	lock  sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+	tags  map[string]map[string]struct{}
+}
+
+func (s *LRUCacheStore) init() {
+	if s.items == nil {
+		s.items = map[string]*list.Element{}
+		s.order = list.New()
+		s.tags = map[string]map[string]struct{}{}
+	}
+}
+
+// Get implements CacheStore
+func (s *LRUCacheStore) Get(key string) (CacheEntry, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.init()
+
+	el, ok := s.items[key]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	s.order.MoveToFront(el)
+	return el.Value.(*lruItem).entry, true
+}
+
+// Set implements CacheStore
+func (s *LRUCacheStore) Set(key string, e CacheEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.init()
+
+	s.deleteLocked(key)
+	s.items[key] = s.order.PushFront(&lruItem{key: key, entry: e})
+	for _, t := range e.Tags {
+		if s.tags[t] == nil {
+			s.tags[t] = map[string]struct{}{}
+		}
+		s.tags[t][key] = struct{}{}
+	}
+
+	size := s.Size
+	if size <= 0 {
+		size = 1000
+	}
+	for s.order.Len() > size {
+		s.deleteLocked(s.order.Back().Value.(*lruItem).key)
+	}
+}
+
+func (s *LRUCacheStore) deleteLocked(key string) {
+	el, ok := s.items[key]
+	if !ok {
+		return
+	}
+	s.order.Remove(el)
+	delete(s.items, key)
+	for _, t := range el.Value.(*lruItem).entry.Tags {
+		delete(s.tags[t], key)
+		if len(s.tags[t]) == 0 {
+			delete(s.tags, t)
+		}
+	}
+}
+
+// Delete implements CacheStore
+func (s *LRUCacheStore) Delete(keys ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.init()
+
+	for _, k := range keys {
+		s.deleteLocked(k)
+	}
+}
+
+// DeleteTag implements CacheStore
+func (s *LRUCacheStore) DeleteTag(tags ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.init()
+
+	for _, t := range tags {
+		for k := range s.tags[t] {
+			s.deleteLocked(k)
+		}
+	}
+}
+
+// CacheOption defines supported parameters used by NewCache
+type CacheOption struct {
+	// how long a response is fresh, REQUIRED
+	TTL time.Duration
+	// how long a response can be served after expired, while revalidating in
+	// background
+	Stale time.Duration
+	// query parameters used to compute cache key. Whole query string is used
+	// if nil.
+	Query []string
+	// request headers used to compute cache key
+	Vary []string
+	// defaults to a LRUCacheStore with default size
+	Store CacheStore
+}
+
+type cacheCall struct {
+	wg    sync.WaitGroup
+	entry CacheEntry
+	data  interface{}
+	err   error
+}
+
+type cacheTagsKey struct{}
+
+type cacheTags struct {
+	lock sync.Mutex
+	tags []string
+}
+
+// CacheTag tags the response, so you can invalidate it with Cache.InvalidateTag
+//
+// It is no-op if the handler is not wrapped by Cache middleware.
+func CacheTag(r jsonapi.Request, tags ...string) {
+	if x, ok := r.R().Context().Value(cacheTagsKey{}).(*cacheTags); ok {
+		x.lock.Lock()
+		defer x.lock.Unlock()
+		x.tags = append(x.tags, tags...)
+	}
+}
+
+// Cache is a middleware caches successful responses of GET and HEAD requests
+//
+//   - Concurrent requests with same key are coalesced: only one of them runs the
+//     handler.
+//   - Client can bypass the cache with "Cache-Control: no-cache" (result is
+//     still cached) or "Cache-Control: no-store".
+//   - X-Cache header is set to HIT, STALE or MISS.
+//   - Only 200 responses are cached, files and jsonapi.Result with other
+//     status codes are not.
+//   - Response headers set by handler are replayed, excepts Set-Cookie,
+//     hop-by-hop headers, rate limit headers and request id.
+//
+// Cache key is computed from method, path, query parameters in opt.Query and
+// request headers in opt.Vary, see Key. Vary header is set accordingly.
+type Cache struct {
+	opt CacheOption
+
+	lock  sync.Mutex
+	calls map[string]*cacheCall
+}
+
+// NewCache creates a Cache
+func NewCache(opt CacheOption) *Cache {
+	if opt.Store == nil {
+		opt.Store = &LRUCacheStore{}
+	}
+	opt.Vary = append([]string(nil), opt.Vary...)
+	for idx, h := range opt.Vary {
+		opt.Vary[idx] = http.CanonicalHeaderKey(h)
+	}
+	return &Cache{
+		opt:   opt,
+		calls: map[string]*cacheCall{},
+	}
+}
+
+// Key computes cache key of the request
+func (c *Cache) Key(r *http.Request) string {
+	buf := &strings.Builder{}
+	buf.WriteString(r.Method + " " + r.URL.Path)
+
+	q := r.URL.Query()
+	if c.opt.Query != nil {
+		sel := url.Values{}
+		for _, k := range c.opt.Query {
+			if v, ok := q[k]; ok {
+				sel[k] = v
+			}
+		}
+		q = sel
+	}
+	if len(q) > 0 {
+		// url.Values.Encode sorts by key
+		buf.WriteString("?" + q.Encode())
+	}
+
+	for _, h := range c.opt.Vary {
+		buf.WriteString("\n" + h + ": " + strings.Join(r.Header.Values(h), ", "))
+	}
+	return buf.String()
+}
+
+// Invalidate removes cached responses by keys
+func (c *Cache) Invalidate(keys ...string) { c.opt.Store.Delete(keys...) }
+
+// InvalidateTag removes cached responses by tags, see CacheTag
+func (c *Cache) InvalidateTag(tags ...string) { c.opt.Store.DeleteTag(tags...) }
+
+// hasDirective checks Cache-Control request header
+func hasDirective(r *http.Request, d string) bool {
+	for _, v := range r.Header.Values("Cache-Control") {
+		for _, x := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(x), d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// discardWriter is used when revalidating in background
+type discardWriter http.Header
+
+func (w discardWriter) Header() http.Header       { return http.Header(w) }
+func (discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (discardWriter) WriteHeader(int)             {}
+
+// privateHeaders are specific to the request or the client, so they are never
+// saved for other requests
+var privateHeaders = map[string]bool{}
+
+func init() {
+	for _, k := range []string{
+		"Set-Cookie", "X-Request-ID",
+		"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+		// hop-by-hop headers
+		"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+		"Proxy-Connection", "TE", "Trailer", "Transfer-Encoding", "Upgrade",
+	} {
+		privateHeaders[http.CanonicalHeaderKey(k)] = true
+	}
+}
+
+// changedHeader returns headers in after which are different from before,
+// private headers are excluded
+func changedHeader(r jsonapi.Request, before, after http.Header) http.Header {
+	skip := map[string]bool{}
+	for _, v := range after.Values("Connection") {
+		for _, k := range strings.Split(v, ",") {
+			skip[http.CanonicalHeaderKey(strings.TrimSpace(k))] = true
+		}
+	}
+	if v, ok := r.R().Context().Value(requestIDKey{}).(requestID); ok {
+		skip[http.CanonicalHeaderKey(v.header)] = true
+	}
+
+	ret := http.Header{}
+	for k, v := range after {
+		if privateHeaders[k] || skip[k] {
+			continue
+		}
+		if strings.Join(before[k], "\n") != strings.Join(v, "\n") {
+			ret[k] = append([]string(nil), v...)
+		}
+	}
+	return ret
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+}
+
+// run executes the handler and caches the result. Concurrent calls with same
+// key are coalesced.
+func (c *Cache) run(key string, h jsonapi.Handler, r jsonapi.Request) (*cacheCall, bool) {
+	c.lock.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.lock.Unlock()
+		call.wg.Wait()
+		return call, false
+	}
+	call := &cacheCall{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.calls, key)
+		c.lock.Unlock()
+		call.wg.Done()
+	}()
+
+	tags := &cacheTags{}
+	before := r.W().Header().Clone()
+	call.data, call.err = h(r.WithValue(cacheTagsKey{}, tags))
+	if call.err != nil {
+		return call, true
+	}
+	status, extra, data := jsonapi.UnwrapResult(call.data)
+	if status != http.StatusOK {
+		return call, true
+	}
+	buf, doc, err := encodeResult(r.R(), data)
+	if err != nil {
+		return call, true
+	}
+
+	// remember headers set by handler
+	hdr := changedHeader(r, before, r.W().Header())
+	for k, v := range changedHeader(r, nil, extra) {
+		hdr[k] = v
+	}
+
+	now := time.Now()
+	call.entry = CacheEntry{
+		Data:     buf,
+		Document: doc,
+		Header:   hdr,
+		Tags:     tags.tags,
+		Fresh:    now.Add(c.opt.TTL),
+		Stale:    now.Add(c.opt.TTL + c.opt.Stale),
+	}
+	c.opt.Store.Set(key, call.entry)
+	return call, true
+}
+
+// Middleware is the *real* middleware part of Cache
+func (c *Cache) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	return func(r jsonapi.Request) (data interface{}, err error) {
+		req := r.R()
+		if req.Method != "GET" && req.Method != "HEAD" {
+			return h(r)
+		}
+		if len(c.opt.Vary) > 0 {
+			r.W().Header().Add("Vary", strings.Join(c.opt.Vary, ", "))
+		}
+		if hasDirective(req, "no-store") {
+			return h(r)
+		}
+
+		key := c.Key(req)
+		now := time.Now()
+		if !hasDirective(req, "no-cache") {
+			if e, ok := c.opt.Store.Get(key); ok && now.Before(e.Stale) {
+				status := "HIT"
+				if !now.Before(e.Fresh) {
+					status = "STALE"
+					go c.revalidate(key, h, detach(r))
+				}
+				copyHeader(r.W().Header(), e.Header)
+				r.W().Header().Set("X-Cache", status)
+				return decodeResult(e.Data, e.Document), nil
+			}
+		}
+
+		call, own := c.run(key, h, r)
+		if !own {
+			if call.err != nil {
+				return nil, call.err
+			}
+			copyHeader(r.W().Header(), call.entry.Header)
+			r.W().Header().Set("X-Cache", "HIT")
+			if call.entry.Data == nil {
+				if jsonapi.IsFile(call.data) {
+					// content of file cannot be shared
+					return h(r)
+				}
+				return call.data, nil
+			}
+			return decodeResult(call.entry.Data, call.entry.Document), nil
+		}
+		r.W().Header().Set("X-Cache", "MISS")
+		return call.data, call.err
+	}
+}
+
+// detach creates a request for revalidating in background, which does not
+// refer to body, response writer and context of r, as r finishes soon
+func detach(r jsonapi.Request) jsonapi.Request {
+	req := r.R().Clone(jsonapi.DetachContext(r.R().Context()))
+	req.Body = http.NoBody
+	req.ContentLength = 0
+	return jsonapi.FromHTTP(discardWriter(http.Header{}), req)
+}
+
+// revalidate runs the handler in background with detached request r
+func (c *Cache) revalidate(key string, h jsonapi.Handler, r jsonapi.Request) {
+	c.lock.Lock()
+	_, running := c.calls[key]
+	c.lock.Unlock()
+	if running {
+		return
+	}
+
+	c.run(key, h, r)
+}
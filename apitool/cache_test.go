@@ -0,0 +1,186 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestCacheKey(t *testing.T) {
+	c := NewCache(CacheOption{
+		TTL:   time.Minute,
+		Query: []string{"a", "b"},
+		Vary:  []string{"accept-language"},
+	})
+	req := httptest.NewRequest("GET", "/x?c=1&b=2&a=3", nil)
+	req.Header.Set("Accept-Language", "en")
+
+	expect := "GET /x?a=3&b=2\nAccept-Language: en"
+	if actual := c.Key(req); actual != expect {
+		t.Fatalf("expected %q, got %q", expect, actual)
+	}
+}
+
+func TestCache(t *testing.T) {
+	var cnt int32
+	c := NewCache(CacheOption{TTL: time.Minute})
+	h := c.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		CacheTag(r, "tag")
+		time.Sleep(10 * time.Millisecond)
+		return atomic.AddInt32(&cnt, 1), nil
+	})
+	run := func(cc string) (string, interface{}) {
+		req := httptest.NewRequest("GET", "/", nil)
+		if cc != "" {
+			req.Header.Set("Cache-Control", cc)
+		}
+		w := httptest.NewRecorder()
+		data, _ := h(jsonapi.FromHTTP(w, req))
+		if raw, ok := data.(json.RawMessage); ok {
+			var x int32
+			json.Unmarshal(raw, &x)
+			data = x
+		}
+		return w.Header().Get("X-Cache"), data
+	}
+
+	// coalescing
+	wg := &sync.WaitGroup{}
+	for x := 0; x < 5; x++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run("")
+		}()
+	}
+	wg.Wait()
+	if cnt != 1 {
+		t.Fatalf("expected handler to be executed once, got %d", cnt)
+	}
+
+	if st, data := run(""); st != "HIT" || data != int32(1) {
+		t.Fatalf("unexpected result: %s %v", st, data)
+	}
+	if st, data := run("no-cache"); st != "MISS" || data != int32(2) {
+		t.Fatalf("unexpected result: %s %v", st, data)
+	}
+	if st, data := run(""); st != "HIT" || data != int32(2) {
+		t.Fatalf("unexpected result: %s %v", st, data)
+	}
+
+	c.InvalidateTag("tag")
+	if st, data := run(""); st != "MISS" || data != int32(3) {
+		t.Fatalf("unexpected result: %s %v", st, data)
+	}
+}
+
+func TestCacheStale(t *testing.T) {
+	var cnt int32
+	detached := make(chan bool, 1)
+	c := NewCache(CacheOption{TTL: time.Millisecond, Stale: time.Minute})
+	h := c.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		ret := atomic.AddInt32(&cnt, 1)
+		if ret == 2 {
+			detached <- r.R().Body == http.NoBody && r.R().Context().Err() == nil
+		}
+		return ret, nil
+	})
+	run := func() string {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		w := httptest.NewRecorder()
+		h(jsonapi.FromHTTP(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx)))
+		return w.Header().Get("X-Cache")
+	}
+
+	run()
+	time.Sleep(2 * time.Millisecond)
+	if st := run(); st != "STALE" {
+		t.Fatalf("expected STALE, got %s", st)
+	}
+	for x := 0; x < 100 && atomic.LoadInt32(&cnt) != 2; x++ {
+		time.Sleep(time.Millisecond)
+	}
+	if x := atomic.LoadInt32(&cnt); x != 2 {
+		t.Fatalf("expected revalidation in background, got %d", x)
+	}
+	if !<-detached {
+		t.Fatal("expected revalidation to use a detached request")
+	}
+}
+
+func TestLRUCacheStore(t *testing.T) {
+	s := &LRUCacheStore{Size: 2}
+	s.Set("a", CacheEntry{})
+	s.Set("b", CacheEntry{})
+	s.Get("a")
+	s.Set("c", CacheEntry{})
+
+	if _, ok := s.Get("b"); ok {
+		t.Error("b should be evicted")
+	}
+	if _, ok := s.Get("a"); !ok {
+		t.Error("a should be kept")
+	}
+}
+
+func TestCacheFile(t *testing.T) {
+	var cnt int32
+	c := NewCache(CacheOption{TTL: time.Minute})
+	h := jsonapi.Handler(c.Middleware(ETag(false)(func(r jsonapi.Request) (interface{}, error) {
+		atomic.AddInt32(&cnt, 1)
+		return jsonapi.File{Name: "a.txt", Content: strings.NewReader("0123456789")}, nil
+	})))
+
+	for x := 0; x < 2; x++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Range", "bytes=1-2")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != 206 || w.Body.String() != "12" || w.Header().Get("ETag") != "" {
+			t.Fatalf("unexpected response: %d %q %v", w.Code, w.Body.String(), w.Header())
+		}
+	}
+	if cnt != 2 {
+		t.Fatalf("file should not be cached, handler called %d times", cnt)
+	}
+}
+
+func TestCachePrivateHeader(t *testing.T) {
+	c := NewCache(CacheOption{TTL: time.Minute})
+	h := RequestID{}.Middleware(c.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		http.SetCookie(r.W(), &http.Cookie{Name: "sid", Value: "secret"})
+		r.W().Header().Set("X-Custom", "x")
+		return 1, nil
+	}))
+
+	for _, st := range []string{"MISS", "HIT"} {
+		w := httptest.NewRecorder()
+		h(jsonapi.FromHTTP(w, httptest.NewRequest("GET", "/", nil)))
+		if x := w.Header().Get("X-Cache"); x != st {
+			t.Fatalf("expected %s, got %s", st, x)
+		}
+		if x := w.Header().Get("X-Custom"); x != "x" {
+			t.Errorf("%s: expected header to be replayed, got %q", st, x)
+		}
+		if x := w.Header().Values("X-Request-ID"); len(x) != 1 {
+			t.Errorf("%s: unexpected request id: %v", st, x)
+		}
+		cookies := w.Header().Values("Set-Cookie")
+		if st == "HIT" && len(cookies) > 0 {
+			t.Errorf("expected cookie not to be replayed, got %v", cookies)
+		}
+	}
+}
@@ -26,6 +26,7 @@ package callapi
 // signature with http header, you can:
 //
 //   - NewEP("POST", myApiUrl).With(aFunctionToSetHeader)
+//   - NewEP("POST", myApiUrl).With(Signer{KeyID: id, Secret: secret}.Sign)
 //   - Write your own Endpoint
 func EP(method, url string) Caller {
 	return NewEP(method, url).DefaultCaller()
@@ -0,0 +1,90 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package callapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Iterator iterates over a paginated list API, following "next" link in
+// top-level "links" member until it is missing.
+//
+//	it := Iterate[Article](ctx, Builder{}, host+"/articles?limit=50")
+//	for it.Next() {
+//		fmt.Println(it.Item())
+//	}
+//	if err := it.Err(); err != nil {
+//		// handle error
+//	}
+type Iterator[T any] struct {
+	ctx  context.Context
+	b    Builder
+	next string
+
+	items []T
+	cur   T
+	err   error
+}
+
+// Iterate creates an Iterator starts from uri. Requests are created by b.Maker
+// with GET method and sent by b.Sender; b.Parser is ignored.
+func Iterate[T any](ctx context.Context, b Builder, uri string) *Iterator[T] {
+	return &Iterator[T]{ctx: ctx, b: b, next: uri}
+}
+
+// fetch loads next page
+func (it *Iterator[T]) fetch() {
+	base, err := url.Parse(it.next)
+	if err != nil {
+		it.err = EClient{err}
+		return
+	}
+
+	var links struct {
+		Next string `json:"next"`
+	}
+	var items []T
+	b := it.b.UseParser(DocumentParser(&links, nil))
+	if err = b.EP(http.MethodGet, it.next).Call(it.ctx, nil, &items); err != nil {
+		it.err = err
+		return
+	}
+
+	it.items, it.next = items, ""
+	if links.Next != "" {
+		next, err := base.Parse(links.Next)
+		if err != nil {
+			it.err = EFormat{err}
+			return
+		}
+		it.next = next.String()
+	}
+}
+
+// Next advances to next item, returns false if there's no more item or any
+// error occurred
+func (it *Iterator[T]) Next() bool {
+	for len(it.items) == 0 {
+		if it.err != nil || it.next == "" {
+			return false
+		}
+		it.fetch()
+		if len(it.items) == 0 {
+			// stop at empty page, prevents infinite loop
+			it.next = ""
+		}
+	}
+
+	it.cur, it.items = it.items[0], it.items[1:]
+	return true
+}
+
+// Item returns current item
+func (it *Iterator[T]) Item() T { return it.cur }
+
+// Err returns the error occurred while fetching pages
+func (it *Iterator[T]) Err() error { return it.err }
@@ -0,0 +1,47 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package callapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestIterator(t *testing.T) {
+	mux := http.NewServeMux()
+	jsonapi.Register(mux, []jsonapi.API{
+		{Pattern: "/list", Handler: func(r jsonapi.Request) (interface{}, error) {
+			offset, _ := strconv.Atoi(r.R().URL.Query().Get("offset"))
+			doc := jsonapi.Document{Data: []int{offset, offset + 1}}
+			if offset < 4 {
+				doc.Links = map[string]string{
+					"next": "/list?offset=" + strconv.Itoa(offset+2),
+				}
+			}
+			return doc, nil
+		}},
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	it := Iterate[int](context.TODO(), Builder{}, server.URL+"/list")
+	var actual []int
+	for it.Next() {
+		actual = append(actual, it.Item())
+	}
+
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expect := []int{0, 1, 2, 3, 4, 5}; !reflect.DeepEqual(expect, actual) {
+		t.Fatalf("expected %v, got %v", expect, actual)
+	}
+}
@@ -0,0 +1,186 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package callapi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// MultipartFile is a file to be uploaded
+type MultipartFile struct {
+	// name of the form field
+	Field    string
+	Filename string
+	// defaults to application/octet-stream
+	ContentType string
+	Body        io.Reader
+	// size of Body, Content-Length of the request is computed if all files
+	// have known size. Zero or negative value means unknown, and the request
+	// is sent chunked.
+	Size int64
+}
+
+// Multipart is the param of [MultipartEP]
+type Multipart struct {
+	Fields url.Values
+	Files  []MultipartFile
+}
+
+// Progress receives upload progress, total is negative if unknown
+type Progress func(sent, total int64)
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func (f MultipartFile) header() textproto.MIMEHeader {
+	ct := f.ContentType
+	if ct == "" {
+		ct = "application/octet-stream"
+	}
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", fmt.Sprintf(
+		`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(f.Field), quoteEscaper.Replace(f.Filename),
+	))
+	h.Set("Content-Type", ct)
+	return h
+}
+
+// write writes whole form to w, file bodies are skipped if skip is true
+func (m *Multipart) write(w *multipart.Writer, skip bool) error {
+	for k, vals := range m.Fields {
+		for _, v := range vals {
+			if err := w.WriteField(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range m.Files {
+		pw, err := w.CreatePart(f.header())
+		if err != nil {
+			return err
+		}
+		if skip {
+			continue
+		}
+		if _, err = io.Copy(pw, f.Body); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// size computes the size of encoded form, returns -1 if unknown
+func (m *Multipart) size(boundary string) int64 {
+	cnt := &countWriter{}
+	w := multipart.NewWriter(cnt)
+	w.SetBoundary(boundary)
+	m.write(w, true)
+
+	ret := cnt.n
+	for _, f := range m.Files {
+		if f.Size <= 0 {
+			return -1
+		}
+		ret += f.Size
+	}
+	return ret
+}
+
+type countWriter struct{ n int64 }
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	w.n += int64(len(b))
+	return len(b), nil
+}
+
+// multipartBody starts writing the form on first Read, so nothing is leaked
+// if the request is dropped before sending, by a failed decorator for example
+type multipartBody struct {
+	once  sync.Once
+	pr    *io.PipeReader
+	write func(*io.PipeWriter)
+}
+
+func (b *multipartBody) Read(buf []byte) (int, error) {
+	b.once.Do(func() {
+		pr, pw := io.Pipe()
+		b.pr = pr
+		go b.write(pw)
+	})
+	if b.pr == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return b.pr.Read(buf)
+}
+
+func (b *multipartBody) Close() error {
+	b.once.Do(func() {})
+	if b.pr == nil {
+		return nil
+	}
+	return b.pr.Close()
+}
+
+type progressReader struct {
+	io.ReadCloser
+	sent  int64
+	total int64
+	f     Progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	if n > 0 {
+		r.sent += int64(n)
+		r.f(r.sent, r.total)
+	}
+	return n, err
+}
+
+// MultipartEP creates an Endpoint which sends param, a Multipart or
+// *Multipart, as multipart/form-data. Files are streamed, so they are never
+// buffered entirely in memory. progress is called whenever some data is sent,
+// pass nil if you don't care.
+//
+// Since the body is streamed, the request cannot be retried.
+func MultipartEP(method, url string, progress Progress) Endpoint {
+	return func(ctx context.Context, param any) (*http.Request, error) {
+		var m *Multipart
+		switch x := param.(type) {
+		case Multipart:
+			m = &x
+		case *Multipart:
+			m = x
+		default:
+			return nil, fmt.Errorf("callapi: unsupported param type %T for multipart", param)
+		}
+
+		form := multipart.NewWriter(nil)
+		size := m.size(form.Boundary())
+		var body io.ReadCloser = &multipartBody{write: func(pw *io.PipeWriter) {
+			w := multipart.NewWriter(pw)
+			w.SetBoundary(form.Boundary())
+			pw.CloseWithError(m.write(w, false))
+		}}
+		if progress != nil {
+			body = &progressReader{ReadCloser: body, total: size, f: progress}
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, body)
+		if err != nil {
+			return nil, err
+		}
+		req.ContentLength = size
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		return req, nil
+	}
+}
@@ -6,6 +6,7 @@ package callapi
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
 
 	"github.com/raohwork/jsonapi"
@@ -20,10 +21,14 @@ type callResp struct {
 	Errors []jsonapi.ErrObj `json:"errors"`
 }
 
-// DefaultParser parses response of a jsonapi
+// DefaultParser parses response of a jsonapi, 204 No Content is treated as
+// success without data.
 //
 // If any io or json parsing error occurred, an EFormat is returned.
 func DefaultParser(resp *http.Response, result interface{}) error {
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
 	var res callResp
 	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
 		return EFormat{err}
@@ -41,3 +46,79 @@ func DefaultParser(resp *http.Response, result interface{}) error {
 
 	return res.Errors[0].AsError()
 }
+
+type docResp struct {
+	Data   *json.RawMessage `json:"data"`
+	Errors []jsonapi.ErrObj `json:"errors"`
+	Links  *json.RawMessage `json:"links"`
+	Meta   *json.RawMessage `json:"meta"`
+}
+
+// DocumentParser creates a Parser like [DefaultParser], but also decodes
+// top-level "links" and "meta" members (see [jsonapi.Document]) into links and
+// meta. Nil links or meta is ignored.
+func DocumentParser(links, meta interface{}) Parser {
+	return func(resp *http.Response, result interface{}) error {
+		if resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		var res docResp
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			return EFormat{err}
+		}
+
+		for _, x := range []struct {
+			raw *json.RawMessage
+			v   interface{}
+		}{
+			{res.Data, result},
+			{res.Links, links},
+			{res.Meta, meta},
+		} {
+			if x.raw == nil || x.v == nil {
+				continue
+			}
+			if err := json.Unmarshal([]byte(*x.raw), x.v); err != nil {
+				return EClient{err}
+			}
+		}
+
+		if len(res.Errors) == 0 {
+			return nil
+		}
+
+		return res.Errors[0].AsError()
+	}
+}
+
+// ResourceParser parses response of a jsonapi which returns compound documents
+// (see [jsonapi.MarshalResource]). result must be a pointer to resource struct,
+// or pointer to slice of it. Related resources are filled with "included"
+// member.
+//
+// If any io or json parsing error occurred, an EFormat is returned.
+func ResourceParser(resp *http.Response, result interface{}) error {
+	if resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return EFormat{err}
+	}
+
+	var res callResp
+	if err = json.Unmarshal(buf, &res); err != nil {
+		return EFormat{err}
+	}
+	if len(res.Errors) > 0 {
+		return res.Errors[0].AsError()
+	}
+
+	if res.Data == nil || result == nil {
+		return nil
+	}
+	if err = jsonapi.UnmarshalResource(buf, result); err != nil {
+		return EClient{err}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package callapi
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/raohwork/jsonapi"
+)
+
+type parserPerson struct {
+	ID   int    `jsonapi:"primary,people"`
+	Name string `jsonapi:"attr,name"`
+}
+
+type parserArticle struct {
+	ID     string        `jsonapi:"primary,articles"`
+	Title  string        `jsonapi:"attr,title"`
+	Author *parserPerson `jsonapi:"relation,author"`
+}
+
+func TestResourceParser(t *testing.T) {
+	server := httptest.NewServer(jsonapi.Handler(func(r jsonapi.Request) (interface{}, error) {
+		if r.R().URL.Query().Get("fail") != "" {
+			return nil, jsonapi.E404.SetData("not found")
+		}
+		return []parserArticle{
+			{ID: "a", Title: "t", Author: &parserPerson{ID: 1, Name: "john"}},
+		}, nil
+	}))
+	defer server.Close()
+
+	b := Builder{}.UseParser(ResourceParser)
+	var actual []parserArticle
+	err := b.EP("GET", server.URL+"?include=author").Call(context.TODO(), nil, &actual)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(actual) != 1 || actual[0].Title != "t" || actual[0].Author == nil || actual[0].Author.Name != "john" {
+		t.Fatalf("unexpected result: %+v", actual)
+	}
+
+	err = b.EP("GET", server.URL+"?fail=1").Call(context.TODO(), nil, &actual)
+	if err == nil || err.Error() != "not found" {
+		t.Fatalf("expected error, got %v", err)
+	}
+}
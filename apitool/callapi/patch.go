@@ -0,0 +1,147 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package callapi
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// PatchOp is an operation of JSON Patch (RFC 6902)
+type PatchOp struct {
+	Op    string
+	Path  string
+	From  string
+	Value any
+}
+
+// MarshalJSON implements json.Marshaler. Value is always encoded for "add",
+// "replace" and "test" operations, even if it is nil.
+func (o PatchOp) MarshalJSON() ([]byte, error) {
+	m := map[string]any{"op": o.Op, "path": o.Path}
+	switch o.Op {
+	case "add", "replace", "test":
+		m["value"] = o.Value
+	case "move", "copy":
+		m["from"] = o.From
+	}
+	return json.Marshal(m)
+}
+
+// JSONPatch is a JSON Patch document
+//
+//	p := callapi.JSONPatch{}.
+//	    Test("/version", 3).
+//	    Replace("/title", "new title").
+//	    Remove("/draft")
+//	err := callapi.JSONPatchEP("PATCH", uri).DefaultCaller().Call(ctx, p, &result)
+type JSONPatch []PatchOp
+
+// Add appends an "add" operation
+func (p JSONPatch) Add(path string, v any) JSONPatch {
+	return append(p, PatchOp{Op: "add", Path: path, Value: v})
+}
+
+// Remove appends a "remove" operation
+func (p JSONPatch) Remove(path string) JSONPatch {
+	return append(p, PatchOp{Op: "remove", Path: path})
+}
+
+// Replace appends a "replace" operation
+func (p JSONPatch) Replace(path string, v any) JSONPatch {
+	return append(p, PatchOp{Op: "replace", Path: path, Value: v})
+}
+
+// Move appends a "move" operation
+func (p JSONPatch) Move(from, path string) JSONPatch {
+	return append(p, PatchOp{Op: "move", From: from, Path: path})
+}
+
+// Copy appends a "copy" operation
+func (p JSONPatch) Copy(from, path string) JSONPatch {
+	return append(p, PatchOp{Op: "copy", From: from, Path: path})
+}
+
+// Test appends a "test" operation
+func (p JSONPatch) Test(path string, v any) JSONPatch {
+	return append(p, PatchOp{Op: "test", Path: path, Value: v})
+}
+
+func withContentType(ct string) func(*http.Request) (*http.Request, error) {
+	return func(req *http.Request) (*http.Request, error) {
+		if req.Body != nil && req.Body != http.NoBody {
+			req.Header.Set("Content-Type", ct)
+		}
+		return req, nil
+	}
+}
+
+// MergePatchEP creates an Endpoint which sends param as JSON Merge Patch (RFC
+// 7396). Use map or struct with pointer fields, and nil to remove a member.
+// See [MergePatchOf] if you have both old and new value.
+//
+// It can be used as [Builder.Maker].
+func MergePatchEP(method, url string) Endpoint {
+	return DefaultEncoder().EP(method, url).
+		With(withContentType("application/merge-patch+json"))
+}
+
+// JSONPatchEP creates an Endpoint which sends param, usually a [JSONPatch], as
+// JSON Patch (RFC 6902).
+//
+// It can be used as [Builder.Maker].
+func JSONPatchEP(method, url string) Endpoint {
+	return DefaultEncoder().EP(method, url).
+		With(withContentType("application/json-patch+json"))
+}
+
+// MergePatchOf computes a JSON Merge Patch which changes old to new. Both
+// values are encoded to JSON before comparing.
+func MergePatchOf(old, new any) (json.RawMessage, error) {
+	var a, b any
+	for _, x := range []struct {
+		v   any
+		ret *any
+	}{{old, &a}, {new, &b}} {
+		buf, err := json.Marshal(x.v)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(buf, x.ret); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(mergeDiff(a, b))
+}
+
+func mergeDiff(a, b any) any {
+	x, ok1 := a.(map[string]any)
+	y, ok2 := b.(map[string]any)
+	if !ok1 || !ok2 {
+		return b
+	}
+
+	ret := map[string]any{}
+	for k := range x {
+		if _, ok := y[k]; !ok {
+			ret[k] = nil
+		}
+	}
+	for k, v := range y {
+		old, ok := x[k]
+		if !ok {
+			ret[k] = v
+			continue
+		}
+		buf1, _ := json.Marshal(old)
+		buf2, _ := json.Marshal(v)
+		if string(buf1) == string(buf2) {
+			continue
+		}
+		ret[k] = mergeDiff(old, v)
+	}
+	return ret
+}
@@ -0,0 +1,71 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package callapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatchOf(t *testing.T) {
+	cases := []struct {
+		name   string
+		old    string
+		new    string
+		expect string
+	}{
+		{"unchanged", `{"a":1,"b":[1,2]}`, `{"a":1,"b":[1,2]}`, `{}`},
+		{"set", `{"a":1}`, `{"a":2,"b":"x"}`, `{"a":2,"b":"x"}`},
+		{"removed", `{"a":1,"b":2}`, `{"a":1}`, `{"b":null}`},
+		{"to-null", `{"a":1}`, `{"a":null}`, `{"a":null}`},
+		{"from-null", `{"a":null}`, `{"a":1}`, `{"a":1}`},
+		{"nested", `{"a":{"x":1,"y":2,"z":{"k":1}}}`, `{"a":{"x":1,"y":3,"z":{}}}`, `{"a":{"y":3,"z":{"k":null}}}`},
+		{"object-to-scalar", `{"a":{"x":1}}`, `{"a":1}`, `{"a":1}`},
+		{"scalar-to-object", `{"a":1}`, `{"a":{"x":1}}`, `{"a":{"x":1}}`},
+		{"array", `{"a":[1,2,3]}`, `{"a":[1,3]}`, `{"a":[1,3]}`},
+		{"array-of-objects", `{"a":[{"x":1}]}`, `{"a":[{"x":2}]}`, `{"a":[{"x":2}]}`},
+		{"not-object", `[1]`, `[2]`, `[2]`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var old, new, expect any
+			json.Unmarshal([]byte(c.old), &old)
+			json.Unmarshal([]byte(c.new), &new)
+			json.Unmarshal([]byte(c.expect), &expect)
+
+			buf, err := MergePatchOf(old, new)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var actual any
+			if err = json.Unmarshal(buf, &actual); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(expect, actual) {
+				t.Fatalf("expected %s, got %s", c.expect, buf)
+			}
+		})
+	}
+}
+
+func TestMergePatchOfStruct(t *testing.T) {
+	type item struct {
+		Name  string            `json:"name"`
+		Tags  []string          `json:"tags,omitempty"`
+		Attrs map[string]string `json:"attrs,omitempty"`
+	}
+	old := item{Name: "a", Tags: []string{"x"}, Attrs: map[string]string{"k": "v", "l": "w"}}
+	new := item{Name: "a", Attrs: map[string]string{"k": "v2", "l": "w"}}
+
+	buf, err := MergePatchOf(old, new)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expect := `{"attrs":{"k":"v2"},"tags":null}`; string(buf) != expect {
+		t.Fatalf("expected %s, got %s", expect, buf)
+	}
+}
@@ -0,0 +1,193 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package callapi
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header carrying signature created by [Signer]
+//
+//	X-Signature: keyid=k1,ts=1700000000,nonce=...,headers=content-type;host,sig=...
+const SignatureHeader = "X-Signature"
+
+// SignatureAlgorithm is the first line of canonical request
+const SignatureAlgorithm = "HMAC-SHA256"
+
+// ErrSignSecret indicates Signer.Secret is empty
+var ErrSignSecret = errors.New("callapi: secret of signer is not set")
+
+// Signer signs requests with HMAC-SHA256, the signature is verified by
+// apitool.SignatureVerifier. It is designed to be used with [Endpoint.With]:
+//
+//	signer := Signer{KeyID: "k1", Secret: secret}
+//	ep := NewEP("POST", uri).With(signer.Sign)
+//
+// See [CanonicalRequest] for what is signed.
+type Signer struct {
+	// identifies the secret, so server can rotate keys
+	KeyID string
+	// REQUIRED, Sign returns ErrSignSecret if it is empty
+	Secret []byte
+	// headers to sign, defaults to Host and Content-Type. Host is always
+	// signed.
+	Headers []string
+}
+
+func signedHeaders(headers []string) []string {
+	if len(headers) == 0 {
+		headers = []string{"content-type"}
+	}
+	ret := []string{"host"}
+	for _, h := range headers {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" && h != "host" {
+			ret = append(ret, h)
+		}
+	}
+	sort.Strings(ret)
+	// remove duplicated
+	idx := 0
+	for _, h := range ret {
+		if idx == 0 || ret[idx-1] != h {
+			ret[idx] = h
+			idx++
+		}
+	}
+	return ret[:idx]
+}
+
+func canonicalQuery(raw string) string {
+	q, _ := url.ParseQuery(raw)
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := make([]string, 0, len(q))
+	for _, k := range keys {
+		vals := append([]string{}, q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			ret = append(ret, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+	return strings.Join(ret, "&")
+}
+
+// CanonicalRequest creates the string to be signed, which consists of
+// following lines:
+//
+//	HMAC-SHA256
+//	timestamp (unix seconds)
+//	nonce
+//	METHOD
+//	/escaped/path
+//	query sorted by key and value
+//	signed-header:value (one line per header, in order of headers)
+//	signed header names joined with ";"
+//	hex encoded SHA-256 digest of body
+//
+// headers must be lower-cased and sorted. Values of a header are joined with
+// comma, with surrounding spaces trimmed.
+func CanonicalRequest(req *http.Request, headers []string, ts, nonce string, bodyDigest []byte) string {
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	lines := []string{
+		SignatureAlgorithm,
+		ts,
+		nonce,
+		strings.ToUpper(req.Method),
+		path,
+		canonicalQuery(req.URL.RawQuery),
+	}
+	for _, h := range headers {
+		var vals []string
+		if h == "host" {
+			host := req.Host
+			if host == "" {
+				host = req.URL.Host
+			}
+			vals = []string{host}
+		} else {
+			// copy it, or trimming modifies the header of req
+			vals = append([]string(nil), req.Header.Values(h)...)
+		}
+		for idx, v := range vals {
+			vals[idx] = strings.TrimSpace(v)
+		}
+		lines = append(lines, h+":"+strings.Join(vals, ","))
+	}
+	lines = append(lines, strings.Join(headers, ";"), hex.EncodeToString(bodyDigest))
+	return strings.Join(lines, "\n")
+}
+
+// ComputeSignature computes HMAC-SHA256 of canonical request
+func ComputeSignature(secret []byte, canonical string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, canonical)
+	return mac.Sum(nil)
+}
+
+// Sign signs req and sets the SignatureHeader. Request body is read into
+// memory to compute the digest.
+func (s Signer) Sign(req *http.Request) (*http.Request, error) {
+	if len(s.Secret) == 0 {
+		return nil, ErrSignSecret
+	}
+	if strings.ContainsAny(s.KeyID, ",=") {
+		return nil, errors.New("callapi: key id must not contain ',' or '='")
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		defer req.Body.Close()
+		buf, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = buf
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		req.ContentLength = int64(len(body))
+	}
+	digest := sha256.Sum256(body)
+
+	n := make([]byte, 16)
+	if _, err := rand.Read(n); err != nil {
+		return nil, err
+	}
+	nonce := hex.EncodeToString(n)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := signedHeaders(s.Headers)
+
+	sig := ComputeSignature(s.Secret, CanonicalRequest(req, headers, ts, nonce, digest[:]))
+	req.Header.Set(SignatureHeader, strings.Join([]string{
+		"keyid=" + s.KeyID,
+		"ts=" + ts,
+		"nonce=" + nonce,
+		"headers=" + strings.Join(headers, ";"),
+		"sig=" + base64.RawURLEncoding.EncodeToString(sig),
+	}, ","))
+	return req, nil
+}
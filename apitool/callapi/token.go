@@ -0,0 +1,62 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package callapi
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TokenSource provides access token to be sent in Authorization header.
+// Implementations should cache the token and refresh it before expired.
+type TokenSource func(ctx context.Context) (string, error)
+
+// StaticToken creates a TokenSource always returns token
+func StaticToken(token string) TokenSource {
+	return func(context.Context) (string, error) { return token, nil }
+}
+
+// CachedToken wraps fetch into a TokenSource which caches the token until
+// shortly before it is expired. fetch returns the token and its expiry time.
+// Concurrent calls share a single fetch.
+func CachedToken(fetch func(ctx context.Context) (string, time.Time, error)) TokenSource {
+	var (
+		lock  sync.Mutex
+		token string
+		exp   time.Time
+	)
+	return func(ctx context.Context) (string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		// refresh early, so token will not expire on the way to server
+		if token != "" && time.Now().Add(10*time.Second).Before(exp) {
+			return token, nil
+		}
+		t, e, err := fetch(ctx)
+		if err != nil {
+			return "", err
+		}
+		token, exp = t, e
+		return token, nil
+	}
+}
+
+// BearerToken creates a request modifier sets Authorization header with the
+// token from src. It is designed to be used with [Endpoint.With]:
+//
+//	ep := NewEP("POST", uri).With(BearerToken(src))
+func BearerToken(src TokenSource) func(*http.Request) (*http.Request, error) {
+	return func(req *http.Request) (*http.Request, error) {
+		token, err := src(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req, nil
+	}
+}
@@ -0,0 +1,339 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// Codec implements a content coding
+type Codec struct {
+	// creates a compressor writes to w
+	Encode func(w io.Writer) (io.WriteCloser, error)
+	// creates a decompressor reads from r, leave nil if decompressing request
+	// body is not supported
+	Decode func(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCodec implements "gzip" content coding
+var GzipCodec = Codec{
+	Encode: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+	Decode: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+}
+
+// DeflateCodec implements "deflate" content coding, which is zlib format
+var DeflateCodec = Codec{
+	Encode: func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriter(w), nil },
+	Decode: func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+}
+
+// CompressOption defines supported parameters used by NewCompress
+type CompressOption struct {
+	// supported codings in preferred order, defaults to gzip and deflate
+	Codings []string
+	// implementations of codings, GzipCodec and DeflateCodec are used for
+	// "gzip" and "deflate" if not set
+	Codecs map[string]Codec
+	// response smaller than this is not compressed, defaults to 1024
+	MinSize int
+	// max size of decompressed request body, defaults to 10MB
+	MaxBody int64
+}
+
+// E415Encoding indicates request body is encoded with unsupported coding
+var E415Encoding = jsonapi.E415.SetData("unsupported content encoding")
+
+// negotiate selects a coding according to Accept-Encoding, returns empty string
+// if identity should be used
+func negotiate(accept string, supported []string) string {
+	if accept == "" {
+		return ""
+	}
+
+	q := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		f := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(f[0]))
+		if name == "" {
+			continue
+		}
+		v := 1.0
+		for _, p := range f[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if x, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					v = x
+				}
+			}
+		}
+		q[name] = v
+	}
+
+	ret, best := "", 0.0
+	for _, c := range supported {
+		v, ok := q[c]
+		if !ok {
+			v, ok = q["*"]
+		}
+		if ok && v > best {
+			ret, best = c, v
+		}
+	}
+	return ret
+}
+
+// codingETag appends coding to the opaque part of tag
+func codingETag(tag, coding string) string {
+	if len(opaqueTag(tag)) < 2 || !strings.HasSuffix(tag, `"`) {
+		return tag
+	}
+	return tag[:len(tag)-1] + "-" + coding + `"`
+}
+
+// stripCodingETags removes coding suffix added by codingETag from entity tags
+// in v
+func stripCodingETags(v string, codings []string) string {
+	for _, c := range codings {
+		v = strings.ReplaceAll(v, "-"+c+`"`, `"`)
+	}
+	return v
+}
+
+// compressWriter buffers first few bytes to decide whether to compress
+type compressWriter struct {
+	http.ResponseWriter
+	coding  string
+	codec   Codec
+	minSize int
+
+	code    int
+	buf     bytes.Buffer
+	decided bool
+	enc     io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+// decide writes the header, and creates compressor if needed
+func (w *compressWriter) decide(compress bool) {
+	w.decided = true
+	hdr := w.Header()
+	switch {
+	case hdr.Get("Content-Encoding") != "":
+		compress = false
+	case w.code == http.StatusNoContent || w.code == http.StatusNotModified:
+		compress = false
+	case hdr.Get("Content-Range") != "":
+		// compressing partial content breaks the range
+		compress = false
+	}
+
+	if compress {
+		if enc, err := w.codec.Encode(w.ResponseWriter); err == nil {
+			w.enc = enc
+			hdr.Set("Content-Encoding", w.coding)
+			hdr.Del("Content-Length")
+			// encoded content is a different representation, see RFC 9110
+			// section 8.8.3
+			if tag := hdr.Get("ETag"); tag != "" {
+				hdr.Set("ETag", codingETag(tag, w.coding))
+			}
+		}
+	}
+	if w.code != 0 {
+		w.ResponseWriter.WriteHeader(w.code)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if w.buf.Len()+len(b) < w.minSize {
+			return w.buf.Write(b)
+		}
+		w.decide(true)
+		if err := w.flushBuf(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) flushBuf() (err error) {
+	if w.buf.Len() == 0 {
+		return
+	}
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return
+}
+
+// Flush implements http.Flusher. Response is compressed if not decided yet, as
+// flushing is mostly used to stream data.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if err := w.flushBuf(); err != nil {
+		return
+	}
+	if f, ok := w.enc.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close flushes buffered data and compressor
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		w.decide(false)
+	}
+	err := w.flushBuf()
+	if w.enc != nil {
+		if e := w.enc.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// limitedReader returns an error instead of EOF if limit is exceeded
+type limitedReader struct {
+	io.ReadCloser
+	n int64
+}
+
+// ErrBodyTooLarge is returned when reading decompressed request body larger than
+// CompressOption.MaxBody. Handlers can map it to jsonapi.E413.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+func (r *limitedReader) Read(b []byte) (int, error) {
+	if r.n <= 0 {
+		// check if there's more data
+		var x [1]byte
+		if n, _ := r.ReadCloser.Read(x[:]); n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(b)) > r.n {
+		b = b[:r.n]
+	}
+	n, err := r.ReadCloser.Read(b)
+	r.n -= int64(n)
+	return n, err
+}
+
+// NewCompress creates a middleware which compresses response according to
+// Accept-Encoding, and decompresses request body according to Content-Encoding.
+//
+// Response is not compressed if it is smaller than MinSize, or has
+// Content-Encoding header already (set by ASIS handler for example). Vary
+// header is always set. ETag of compressed response is suffixed with the coding
+// like "tag-gzip", and the suffix is removed from If-None-Match and If-Match
+// before passing to next handler.
+//
+// Request body with unsupported Content-Encoding is rejected with E415Encoding.
+func NewCompress(opt CompressOption) jsonapi.Middleware {
+	if len(opt.Codings) == 0 {
+		opt.Codings = []string{"gzip", "deflate"}
+	}
+	codecs := map[string]Codec{
+		"gzip":    GzipCodec,
+		"deflate": DeflateCodec,
+	}
+	for k, v := range opt.Codecs {
+		codecs[strings.ToLower(k)] = v
+	}
+	if opt.MinSize <= 0 {
+		opt.MinSize = 1024
+	}
+	if opt.MaxBody <= 0 {
+		opt.MaxBody = 10 << 20
+	}
+
+	return func(h jsonapi.Handler) jsonapi.Handler {
+		return func(r jsonapi.Request) (interface{}, error) {
+			req := r.R()
+			if ce := strings.ToLower(req.Header.Get("Content-Encoding")); ce != "" && ce != "identity" {
+				c, ok := codecs[ce]
+				if !ok || c.Decode == nil {
+					return nil, E415Encoding
+				}
+				body, err := c.Decode(req.Body)
+				if err != nil {
+					return nil, jsonapi.E400.SetOrigin(err)
+				}
+				r = jsonapi.ReplaceBody(r, &limitedReader{body, opt.MaxBody})
+				// ReplaceBody makes a shallow copy, clone the header so the
+				// original request is not modified
+				dr := r.R()
+				dr.Header = dr.Header.Clone()
+				dr.Header.Del("Content-Encoding")
+				dr.ContentLength = -1
+			}
+
+			// restore entity tags modified by compressWriter, so ETag and
+			// Precondition see the tags they computed
+			if req := r.R(); req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Match") != "" {
+				r = jsonapi.WrapRequest(r, req.Clone(req.Context()))
+				hdr := r.R().Header
+				for _, k := range []string{"If-None-Match", "If-Match"} {
+					for i, v := range hdr.Values(k) {
+						hdr[k][i] = stripCodingETags(v, opt.Codings)
+					}
+				}
+			}
+
+			r.W().Header().Add("Vary", "Accept-Encoding")
+			coding := negotiate(
+				strings.Join(req.Header.Values("Accept-Encoding"), ","),
+				opt.Codings,
+			)
+			if c, ok := codecs[coding]; ok && c.Encode != nil {
+				r = jsonapi.WrapWriter(r, func(w http.ResponseWriter) http.ResponseWriter {
+					return &compressWriter{
+						ResponseWriter: w,
+						coding:         coding,
+						codec:          c,
+						minSize:        opt.MinSize,
+					}
+				})
+			}
+
+			return h(r)
+		}
+	}
+}
+
+// Compress is a middleware compresses response with gzip or deflate, using
+// default settings of NewCompress
+func Compress(h jsonapi.Handler) jsonapi.Handler {
+	return NewCompress(CompressOption{})(h)
+}
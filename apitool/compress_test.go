@@ -0,0 +1,214 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/raohwork/jsonapi"
+	"github.com/raohwork/jsonapi/apitool/callapi"
+)
+
+func TestNegotiate(t *testing.T) {
+	supported := []string{"gzip", "deflate"}
+	cases := []struct {
+		accept string
+		expect string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip;q=0, *", "deflate"},
+		{"br", ""},
+		{"*;q=0", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.accept, func(t *testing.T) {
+			if actual := negotiate(c.accept, supported); actual != c.expect {
+				t.Fatalf("expected %q, got %q", c.expect, actual)
+			}
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("a", 2048)
+	h := jsonapi.Handler(NewCompress(CompressOption{})(func(r jsonapi.Request) (interface{}, error) {
+		var s string
+		if err := r.Decode(&s); err != nil {
+			return nil, jsonapi.E400.SetOrigin(err)
+		}
+		return s, nil
+	}))
+
+	run := func(body string) *httptest.ResponseRecorder {
+		req, _ := callapi.NewEP("POST", "/").With(callapi.GzipBody)(context.TODO(), body)
+		req.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := run(large)
+	if x := w.Header().Get("Content-Encoding"); x != "gzip" {
+		t.Fatalf("expected gzip, got %q", x)
+	}
+	if x := w.Header().Get("Vary"); x != "Accept-Encoding" {
+		t.Errorf("unexpected Vary: %s", x)
+	}
+	r, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf, _ := io.ReadAll(r)
+	if expect := `{"data":"` + large + `"}` + "\n"; string(buf) != expect {
+		t.Fatalf("unexpected body: %s", buf)
+	}
+
+	w = run("small")
+	if x := w.Header().Get("Content-Encoding"); x != "" {
+		t.Fatalf("small response should not be compressed, got %q", x)
+	}
+	if x := w.Body.String(); x != `{"data":"small"}`+"\n" {
+		t.Fatalf("unexpected body: %s", x)
+	}
+}
+
+func TestCompressASIS(t *testing.T) {
+	h := jsonapi.Handler(Compress(func(r jsonapi.Request) (interface{}, error) {
+		r.W().Header().Set("Content-Encoding", "br")
+		return strings.Repeat("a", 2048), jsonapi.ASIS
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if x := w.Header().Get("Content-Encoding"); x != "br" {
+		t.Fatalf("expected br, got %q", x)
+	}
+	if x := w.Body.Len(); x != 2048 {
+		t.Fatalf("expected 2048 bytes, got %d", x)
+	}
+}
+
+func TestCompressUnsupported(t *testing.T) {
+	h := Compress(func(r jsonapi.Request) (interface{}, error) {
+		return nil, nil
+	})
+	req := httptest.NewRequest("POST", "/", strings.NewReader("x"))
+	req.Header.Set("Content-Encoding", "br")
+	_, err := h(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+	if e, ok := err.(jsonapi.Error); !ok || !e.EqualTo(E415Encoding) {
+		t.Fatalf("expected E415Encoding, got %v", err)
+	}
+}
+
+func TestCompressRequestBody(t *testing.T) {
+	h := NewCompress(CompressOption{MaxBody: 16})(func(r jsonapi.Request) (interface{}, error) {
+		buf, err := io.ReadAll(r.R().Body)
+		if err != nil {
+			return nil, err
+		}
+		return len(buf), nil
+	})
+
+	for _, size := range []int{16, 17} {
+		var body bytes.Buffer
+		gz := gzip.NewWriter(&body)
+		gz.Write(bytes.Repeat([]byte("x"), size))
+		gz.Close()
+
+		req := httptest.NewRequest("POST", "/", &body)
+		req.Header.Set("Content-Encoding", "gzip")
+		data, err := h(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+		switch {
+		case size <= 16 && (err != nil || data != size):
+			t.Errorf("expected %d bytes, got %v %v", size, data, err)
+		case size > 16 && err != ErrBodyTooLarge:
+			t.Errorf("expected ErrBodyTooLarge for %d bytes, got %v", size, err)
+		}
+		if x := req.Header.Get("Content-Encoding"); x != "gzip" {
+			t.Errorf("expected original header to be kept, got %q", x)
+		}
+	}
+}
+
+func TestLimitedReader(t *testing.T) {
+	for _, body := range []string{"xx", "xxx"} {
+		r := &limitedReader{io.NopCloser(iotest.OneByteReader(strings.NewReader(body))), 2}
+		buf, err := io.ReadAll(r)
+		switch {
+		case len(body) <= 2 && (err != nil || string(buf) != body):
+			t.Errorf("expected %q, got %q %v", body, buf, err)
+		case len(body) > 2 && err != ErrBodyTooLarge:
+			t.Errorf("expected ErrBodyTooLarge for %q, got %v", body, err)
+		}
+	}
+}
+
+func TestCompressETag(t *testing.T) {
+	large := strings.Repeat("a", 2048)
+	h := jsonapi.Handler(Compress(ETag(false)(func(r jsonapi.Request) (interface{}, error) {
+		return large, nil
+	})))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	tag := w.Header().Get("ETag")
+	if !strings.HasSuffix(tag, `-gzip"`) {
+		t.Fatalf("expected ETag with coding suffix, got %q", tag)
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("If-None-Match", tag)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 304 {
+		t.Fatalf("expected 304, got %d", w.Code)
+	}
+	if x := req.Header.Get("If-None-Match"); x != tag {
+		t.Errorf("expected original header to be kept, got %q", x)
+	}
+}
+
+func TestCompressFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &compressWriter{
+		ResponseWriter: rec,
+		coding:         "gzip",
+		codec:          GzipCodec,
+		minSize:        1024,
+	}
+	var _ http.Flusher = w
+
+	w.Write([]byte("small"))
+	w.Flush()
+	if !rec.Flushed {
+		t.Fatal("expected underlying writer to be flushed")
+	}
+	r, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(r, buf); err != nil || string(buf) != "small" {
+		t.Fatalf("expected flushed data, got %q %v", buf, err)
+	}
+}
@@ -0,0 +1,187 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// E403CSRF indicates the request is rejected by CSRF middleware. It has
+// application-defined error code "ECSRF", so client can tell it from other
+// 403 errors, and refresh the token for example.
+var E403CSRF = jsonapi.E403.SetCode("ECSRF").SetData("cross-site request rejected")
+
+// CSRF is a middleware protects cookie-authenticated APIs from cross-site
+// request forgery
+//
+// Requests with safe methods (GET, HEAD, OPTIONS, TRACE) and requests
+// carrying any of AuthHeaders are never rejected, since browsers cannot send
+// custom headers cross-site without CORS preflight. Other requests must pass
+// following checks:
+//
+//   - Sec-Fetch-Site must be "same-origin" or "none", or Origin is in Origins.
+//   - Origin, if present, must be same as the request host or in Origins.
+//   - If Secret is set, the token in HeaderName must be same as the one in
+//     CookieName, and signed by Secret (signed double-submit cookie). The
+//     cookie is issued by safe requests, and is readable by javascript.
+//
+// To use it with NewCORS, put NewCORS before CSRF so rejections carry CORS
+// headers, and allow the origin in both of them:
+//
+//	jsonapi.With(apitool.NewCORS(apitool.CORSOption{
+//	    Origin:     "https://app.example.com",
+//	    Credential: true,
+//	})).With(apitool.CSRF{
+//	    Origins: []string{"https://app.example.com"},
+//	    Secret:  secret,
+//	}.Middleware).Register(mux, apis)
+type CSRF struct {
+	// trusted origins like "https://app.example.com"
+	Origins []string
+	// enables double-submit token mode if not empty
+	Secret []byte
+	// optional, binds token to the session, session id for example
+	Bind func(r *http.Request) string
+	// defaults to "csrf_token"
+	CookieName string
+	// defaults to "X-CSRF-Token"
+	HeaderName string
+	// sets Secure attribute of the cookie
+	CookieSecure bool
+	// requests with these headers are exempted, defaults to Authorization
+	AuthHeaders []string
+}
+
+func isSafeMethod(m string) bool {
+	switch m {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+func (c CSRF) trusted(origin string, r *http.Request) bool {
+	for _, o := range c.Origins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// sign computes signature of nonce
+func (c CSRF) sign(r *http.Request, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, c.Secret)
+	mac.Write(nonce)
+	if c.Bind != nil {
+		mac.Write([]byte{0})
+		mac.Write([]byte(c.Bind(r)))
+	}
+	return mac.Sum(nil)
+}
+
+func (c CSRF) newToken(r *http.Request) string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	enc := base64.RawURLEncoding.EncodeToString
+	return enc(nonce) + "." + enc(c.sign(r, nonce))
+}
+
+func (c CSRF) validToken(r *http.Request, token string) bool {
+	n, s, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	nonce, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(sig, c.sign(r, nonce))
+}
+
+// checkOrigin verifies Sec-Fetch-Site and Origin, returns reason if failed
+func (c CSRF) checkOrigin(r *http.Request) string {
+	origin := r.Header.Get("Origin")
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		if origin == "" || !c.trusted(origin, r) {
+			return "cross-site request is not allowed"
+		}
+	}
+
+	if origin != "" && !c.trusted(origin, r) {
+		return "origin is not allowed"
+	}
+	return ""
+}
+
+// Middleware is the *real* middleware part of CSRF
+func (c CSRF) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	// safe to set struct member as it is passed by value
+	if c.CookieName == "" {
+		c.CookieName = "csrf_token"
+	}
+	if c.HeaderName == "" {
+		c.HeaderName = "X-CSRF-Token"
+	}
+	if c.AuthHeaders == nil {
+		c.AuthHeaders = []string{"Authorization"}
+	}
+
+	return func(r jsonapi.Request) (interface{}, error) {
+		req := r.R()
+		var cookie string
+		if x, err := req.Cookie(c.CookieName); err == nil {
+			cookie = x.Value
+		}
+
+		if isSafeMethod(req.Method) {
+			if len(c.Secret) > 0 && !c.validToken(req, cookie) {
+				http.SetCookie(r.W(), &http.Cookie{
+					Name:     c.CookieName,
+					Value:    c.newToken(req),
+					Path:     "/",
+					Secure:   c.CookieSecure,
+					SameSite: http.SameSiteLaxMode,
+				})
+			}
+			return h(r)
+		}
+		for _, x := range c.AuthHeaders {
+			if req.Header.Get(x) != "" {
+				return h(r)
+			}
+		}
+
+		if reason := c.checkOrigin(req); reason != "" {
+			return nil, E403CSRF.SetData(reason)
+		}
+		if len(c.Secret) > 0 {
+			token := req.Header.Get(c.HeaderName)
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(cookie)) != 1 {
+				return nil, E403CSRF.SetData("CSRF token mismatch")
+			}
+			if !c.validToken(req, token) {
+				return nil, E403CSRF.SetData("invalid CSRF token")
+			}
+		}
+		return h(r)
+	}
+}
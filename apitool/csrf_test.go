@@ -0,0 +1,99 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestCSRF(t *testing.T) {
+	const app = "https://app.example.com"
+	mux := http.NewServeMux()
+	jsonapi.With(NewCORS(CORSOption{
+		Origin:     app,
+		Credential: true,
+	})).With(CSRF{
+		Origins: []string{app},
+		Secret:  []byte("secret"),
+	}.Middleware).Register(mux, []jsonapi.API{{Pattern: "/", Handler: func(r jsonapi.Request) (interface{}, error) {
+		return "ok", nil
+	}}})
+
+	run := func(method string, hdr map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "http://api.example.com/", nil)
+		for k, v := range hdr {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	// safe request issues the token
+	w := run("GET", nil)
+	cookies := w.Result().Cookies()
+	if w.Code != 200 || len(cookies) != 1 || cookies[0].Name != "csrf_token" {
+		t.Fatalf("expected token cookie, got %d %v", w.Code, cookies)
+	}
+	token := cookies[0].Value
+	cookie := "csrf_token=" + token
+	forged := CSRF{Secret: []byte("other")}.newToken(httptest.NewRequest("GET", "/", nil))
+
+	cases := []struct {
+		name   string
+		method string
+		hdr    map[string]string
+		code   int
+	}{
+		{"preflight", "OPTIONS", map[string]string{
+			"Origin": "https://evil.com", "Access-Control-Request-Method": "POST",
+		}, 200},
+		{"trusted", "POST", map[string]string{
+			"Origin": app, "Sec-Fetch-Site": "same-site", "Cookie": cookie, "X-CSRF-Token": token,
+		}, 200},
+		{"same-origin", "POST", map[string]string{
+			"Origin": "http://api.example.com", "Sec-Fetch-Site": "same-origin", "Cookie": cookie, "X-CSRF-Token": token,
+		}, 200},
+		{"auth-header", "POST", map[string]string{
+			"Origin": "https://evil.com", "Authorization": "Bearer x",
+		}, 200},
+		{"cross-site", "POST", map[string]string{
+			"Origin": "https://evil.com", "Sec-Fetch-Site": "cross-site", "Cookie": cookie, "X-CSRF-Token": token,
+		}, 403},
+		{"null-origin", "POST", map[string]string{
+			"Origin": "null", "Cookie": cookie, "X-CSRF-Token": token,
+		}, 403},
+		{"cross-site-no-origin", "POST", map[string]string{
+			"Sec-Fetch-Site": "cross-site", "Cookie": cookie, "X-CSRF-Token": token,
+		}, 403},
+		{"missing-token", "POST", map[string]string{"Origin": app, "Cookie": cookie}, 403},
+		{"mismatch", "POST", map[string]string{
+			"Origin": app, "Cookie": cookie, "X-CSRF-Token": forged,
+		}, 403},
+		{"forged", "POST", map[string]string{
+			"Origin": app, "Cookie": "csrf_token=" + forged, "X-CSRF-Token": forged,
+		}, 403},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := run(c.method, c.hdr)
+			if w.Code != c.code {
+				t.Fatalf("expected %d, got %d: %s", c.code, w.Code, w.Body.String())
+			}
+			if x := w.Header().Get("Access-Control-Allow-Origin"); x != app {
+				t.Errorf("expected CORS header, got %s", x)
+			}
+			if c.code == 403 && !strings.Contains(w.Body.String(), `"code":"ECSRF"`) {
+				t.Errorf("unexpected body: %s", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// parseETags parses value of If-Match and If-None-Match header. "*" is returned
+// as is.
+func parseETags(v string) (ret []string) {
+	for {
+		v = strings.TrimLeft(v, " \t,")
+		if v == "" {
+			return
+		}
+		if v[0] == '*' {
+			ret = append(ret, "*")
+			v = v[1:]
+			continue
+		}
+
+		weak := strings.HasPrefix(v, "W/")
+		if weak {
+			v = v[2:]
+		}
+		if v == "" || v[0] != '"' {
+			// malformed, skip to next comma
+			idx := strings.IndexByte(v, ',')
+			if idx < 0 {
+				return
+			}
+			v = v[idx:]
+			continue
+		}
+		idx := strings.IndexByte(v[1:], '"')
+		if idx < 0 {
+			return
+		}
+		tag := v[:idx+2]
+		if weak {
+			tag = "W/" + tag
+		}
+		ret = append(ret, tag)
+		v = v[idx+2:]
+	}
+}
+
+// opaqueTag strips weak indicator
+func opaqueTag(tag string) string {
+	return strings.TrimPrefix(tag, "W/")
+}
+
+// matchETag tests if tag matches any of the list, using weak comparison if
+// weak is true, strong comparison otherwise
+func matchETag(list []string, tag string, weak bool) bool {
+	for _, x := range list {
+		if x == "*" {
+			return true
+		}
+		if weak {
+			if opaqueTag(x) == opaqueTag(tag) {
+				return true
+			}
+			continue
+		}
+		if !strings.HasPrefix(x, "W/") && x == tag && !strings.HasPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ComputeETag computes an ETag from encoded data
+func ComputeETag(data interface{}, weak bool) (string, error) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(buf)
+	ret := `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+	if weak {
+		ret = "W/" + ret
+	}
+	return ret, nil
+}
+
+// ETag creates a middleware which handles ETag and If-None-Match
+//
+// If handler does not set ETag response header, it is computed from the data
+// returned by handler with ComputeETag. If the ETag matches If-None-Match
+// request header (using weak comparison), the response is discarded and 304 is
+// replied.
+//
+// It's no-op if handler returns any error, a jsonapi.File or a jsonapi.Result
+// with status code other than 200, or request method is not GET or HEAD.
+//
+// As described in RFC 9110, If-Modified-Since is ignored when If-None-Match is
+// present, so it can be used with LastModify in any order.
+func ETag(weak bool) jsonapi.Middleware {
+	return func(h jsonapi.Handler) jsonapi.Handler {
+		return func(r jsonapi.Request) (data interface{}, err error) {
+			if data, err = h(r); err != nil {
+				return
+			}
+			if m := r.R().Method; m != "GET" && m != "HEAD" {
+				return
+			}
+			status, _, inner := jsonapi.UnwrapResult(data)
+			if status != http.StatusOK || jsonapi.IsFile(inner) {
+				// files are handled by http.ServeContent
+				return
+			}
+
+			tag := r.W().Header().Get("ETag")
+			if tag == "" {
+				doc, e := jsonapi.ResourceDocument(r.R(), inner)
+				if e != nil {
+					return
+				}
+				if tag, e = ComputeETag(doc, weak); e != nil {
+					return
+				}
+				r.W().Header().Set("ETag", tag)
+			}
+
+			v := strings.Join(r.R().Header.Values("If-None-Match"), ",")
+			if v != "" && matchETag(parseETags(v), tag, true) {
+				return nil, jsonapi.E304
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestParseETags(t *testing.T) {
+	cases := []struct {
+		value  string
+		expect []string
+	}{
+		{`"a"`, []string{`"a"`}},
+		{`*`, []string{`*`}},
+		{`"a", W/"b" ,"c,d"`, []string{`"a"`, `W/"b"`, `"c,d"`}},
+		{`bad, "a"`, []string{`"a"`}},
+		{`"unterminated`, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.value, func(t *testing.T) {
+			if actual := parseETags(c.value); !reflect.DeepEqual(actual, c.expect) {
+				t.Fatalf("expected %#v, got %#v", c.expect, actual)
+			}
+		})
+	}
+}
+
+func TestETag(t *testing.T) {
+	date := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	h := LastModify(ETag(false)(func(r jsonapi.Request) (interface{}, error) {
+		r.W().Header().Set("Last-Modified", date)
+		return "hello", nil
+	}))
+	tag, _ := ComputeETag("hello", false)
+
+	cases := []struct {
+		name  string
+		inm   string
+		ims   string
+		is304 bool
+	}{
+		{name: "none"},
+		{name: "match", inm: tag, is304: true},
+		{name: "weak-match", inm: "W/" + tag, is304: true},
+		{name: "star", inm: "*", is304: true},
+		{name: "list", inm: `"x", ` + tag, is304: true},
+		{name: "mismatch", inm: `"x"`},
+		{name: "ims", ims: date, is304: true},
+		{name: "inm-precedes-ims", inm: `"x"`, ims: date},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if c.inm != "" {
+				req.Header.Set("If-None-Match", c.inm)
+			}
+			if c.ims != "" {
+				req.Header.Set("If-Modified-Since", c.ims)
+			}
+			w := httptest.NewRecorder()
+			_, err := h(jsonapi.FromHTTP(w, req))
+
+			if is304 := err != nil && err.(jsonapi.Error).Code == 304; is304 != c.is304 {
+				t.Fatalf("expected 304 = %v, got %v", c.is304, err)
+			}
+			if x := w.Header().Get("ETag"); x != tag {
+				t.Errorf("expected ETag %s, got %s", tag, x)
+			}
+		})
+	}
+}
@@ -0,0 +1,446 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// E400Fields indicates the fields parameter is malformed
+var E400Fields = jsonapi.E400.SetData("invalid fields parameter")
+
+// fieldTree is parsed field paths, nil means all fields
+type fieldTree map[string]fieldTree
+
+func (t fieldTree) add(path string) bool {
+	for _, name := range strings.Split(path, ".") {
+		if name == "" {
+			return false
+		}
+		sub, ok := t[name]
+		if ok && sub == nil {
+			// whole object is selected already
+			return true
+		}
+		if !ok {
+			sub = fieldTree{}
+			t[name] = sub
+		}
+		t = sub
+	}
+	for k := range t {
+		delete(t, k)
+	}
+	return true
+}
+
+// normalize converts empty subtrees (leaves) to nil
+func (t fieldTree) normalize() fieldTree {
+	if len(t) == 0 {
+		return nil
+	}
+	for k, v := range t {
+		t[k] = v.normalize()
+	}
+	return t
+}
+
+// parseFieldList parses comma-separated field paths. Empty string is parsed as
+// an empty (non-nil) tree, which means no field is requested.
+func parseFieldList(v string) (fieldTree, bool) {
+	ret := fieldTree{}
+	if strings.TrimSpace(v) == "" {
+		return ret, true
+	}
+	for _, path := range strings.Split(v, ",") {
+		if !ret.add(strings.TrimSpace(path)) {
+			return nil, false
+		}
+	}
+	return ret.normalize(), true
+}
+
+// FieldSet is parsed sparse fieldsets, see SparseFields
+//
+// Methods of nil FieldSet report every field is requested.
+type FieldSet struct {
+	fields fieldTree
+	types  map[string]fieldTree
+}
+
+type fieldSetKey struct{}
+
+// FieldsFrom retrieves FieldSet parsed by SparseFields, returns nil if not
+// presented
+func FieldsFrom(ctx context.Context) *FieldSet {
+	ret, _ := ctx.Value(fieldSetKey{}).(*FieldSet)
+	return ret
+}
+
+// ParseFields parses "fields" and "fields[type]" parameters, returns nil if
+// none of them is presented
+func ParseFields(q url.Values) (*FieldSet, error) {
+	var ret *FieldSet
+	for k, v := range q {
+		if k != "fields" && !(strings.HasPrefix(k, "fields[") && strings.HasSuffix(k, "]")) {
+			continue
+		}
+		tree, ok := parseFieldList(strings.Join(v, ","))
+		if !ok {
+			return nil, E400Fields
+		}
+
+		if ret == nil {
+			ret = &FieldSet{}
+		}
+		if k == "fields" {
+			ret.fields = tree
+			continue
+		}
+		typ := k[len("fields[") : len(k)-1]
+		if typ == "" {
+			return nil, E400Fields
+		}
+		if ret.types == nil {
+			ret.types = map[string]fieldTree{}
+		}
+		ret.types[typ] = tree
+	}
+	return ret, nil
+}
+
+func (t fieldTree) has(path string) bool {
+	if t == nil {
+		return true
+	}
+	for _, name := range strings.Split(path, ".") {
+		sub, ok := t[name]
+		if !ok {
+			return false
+		}
+		if sub == nil {
+			return true
+		}
+		t = sub
+	}
+	return true
+}
+
+// Has reports whether field at path ("a.b" for example) is requested by
+// "fields" parameter. Parent of a requested field is also treated as
+// requested.
+func (s *FieldSet) Has(path string) bool {
+	if s == nil {
+		return true
+	}
+	return s.fields.has(path)
+}
+
+// HasType reports whether field of resource typ is requested by "fields[typ]"
+// parameter
+func (s *FieldSet) HasType(typ, field string) bool {
+	if s == nil {
+		return true
+	}
+	t, ok := s.types[typ]
+	if !ok {
+		return true
+	}
+	return t.has(field)
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// jsonFields lists encoded field names of a struct type, fields of embedded
+// structs are included
+func jsonFields(t reflect.Type, ret map[string]reflect.Type) {
+	for x := 0; x < t.NumField(); x++ {
+		f := t.Field(x)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			jsonFields(ft, ret)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := ret[name]; !ok {
+			ret[name] = f.Type
+		}
+	}
+}
+
+// validateFields checks if all fields in tree exist in t, returns first unknown
+// field path. Fields of maps, interfaces and custom marshalers are not checked.
+func validateFields(t reflect.Type, tree fieldTree, prefix string) string {
+	if tree == nil || t == nil {
+		return ""
+	}
+	for {
+		if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+			return ""
+		}
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array:
+			t = t.Elem()
+			continue
+		case reflect.Struct:
+		default:
+			return ""
+		}
+		break
+	}
+
+	fields := map[string]reflect.Type{}
+	jsonFields(t, fields)
+	for name, sub := range tree {
+		ft, ok := fields[name]
+		if !ok {
+			return prefix + name
+		}
+		if ret := validateFields(ft, sub, prefix+name+"."); ret != "" {
+			return ret
+		}
+	}
+	return ""
+}
+
+// project removes fields not in tree from decoded json value
+func project(v interface{}, tree fieldTree) interface{} {
+	if tree == nil {
+		return v
+	}
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, val := range x {
+			sub, ok := tree[k]
+			if !ok {
+				delete(x, k)
+				continue
+			}
+			x[k] = project(val, sub)
+		}
+	case []interface{}:
+		for idx, val := range x {
+			x[idx] = project(val, tree)
+		}
+	}
+	return v
+}
+
+// projectTypes applies "fields[type]" to every resource object in v
+func projectTypes(v interface{}, types map[string]fieldTree) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if typ, ok := x["type"].(string); ok {
+			if tree, ok := types[typ]; ok {
+				attr, ok1 := x["attributes"].(map[string]interface{})
+				rel, ok2 := x["relationships"].(map[string]interface{})
+				if ok1 || ok2 {
+					project(attr, tree)
+					project(rel, tree)
+				} else {
+					for k, val := range x {
+						if k != "type" && k != "id" && !tree.has(k) {
+							delete(x, k)
+							continue
+						}
+						x[k] = project(val, tree[k])
+					}
+				}
+			}
+		}
+		for _, val := range x {
+			projectTypes(val, types)
+		}
+	case []interface{}:
+		for _, val := range x {
+			projectTypes(val, types)
+		}
+	}
+}
+
+// applyObject applies "fields[type]" to a resource object in place
+func (s *FieldSet) applyObject(obj *jsonapi.ResourceObject) {
+	tree, ok := s.types[obj.Type]
+	if !ok || tree == nil {
+		return
+	}
+	for k := range obj.Attributes {
+		if _, ok := tree[k]; !ok {
+			delete(obj.Attributes, k)
+		}
+	}
+	for k := range obj.Relationships {
+		if _, ok := tree[k]; !ok {
+			delete(obj.Relationships, k)
+		}
+	}
+}
+
+// validateTypes checks if names in "fields[type]" are attributes or
+// relationships of the resource type, returns first unknown one as "type.name".
+// Only resources (see jsonapi.IsResource) are checked.
+func (s *FieldSet) validateTypes(data interface{}) string {
+	switch x := data.(type) {
+	case jsonapi.Document:
+		data = x.Data
+	case *jsonapi.Document:
+		if x == nil {
+			return ""
+		}
+		data = x.Data
+	}
+
+	known := jsonapi.ResourceFields(data)
+	for typ, tree := range s.types {
+		names, ok := known[typ]
+		if !ok {
+			continue
+		}
+	next:
+		for name := range tree {
+			for _, x := range names {
+				if x == name {
+					continue next
+				}
+			}
+			return typ + "." + name
+		}
+	}
+	return ""
+}
+
+// Apply projects data according to the FieldSet
+//
+// It validates fields against type of data, and returns E400Fields if any of
+// them is unknown. data is encoded to JSON and decoded again, so returned value
+// consists of maps and slices. If data is a jsonapi.Document, only its Data
+// and Included members are projected.
+func (s *FieldSet) Apply(data interface{}) (interface{}, error) {
+	if s == nil || data == nil {
+		return data, nil
+	}
+	switch x := data.(type) {
+	case *jsonapi.Document:
+		if x == nil {
+			return data, nil
+		}
+		data = *x
+	}
+	if doc, ok := data.(jsonapi.Document); ok {
+		v, err := s.Apply(doc.Data)
+		doc.Data = v
+		for _, obj := range doc.Included {
+			s.applyObject(obj)
+		}
+		return doc, err
+	}
+
+	if path := validateFields(reflect.TypeOf(data), s.fields, ""); path != "" {
+		return nil, E400Fields.SetData("unknown field: " + path)
+	}
+
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return nil, jsonapi.E500.SetOrigin(err).SetData("Failed to marshal data")
+	}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var v interface{}
+	if err = dec.Decode(&v); err != nil {
+		return nil, jsonapi.E500.SetOrigin(err).SetData("Failed to marshal data")
+	}
+
+	if s.types != nil {
+		projectTypes(v, s.types)
+	}
+	return project(v, s.fields), nil
+}
+
+// SparseFields is a middleware to support sparse fieldsets
+//
+// Client can pass "fields=a,b.c" to get only field "a" and field "c" of "b" in
+// returned data, which applies to nested objects and slices. Names are the keys
+// in encoded JSON, so json tags are honoured. Unknown names are rejected with
+// E400Fields.
+//
+// JSON:API style "fields[type]=a,b" is also supported. It applies to every
+// object with matching "type" member: "attributes" and "relationships" are
+// projected if presented, or other members excepts "type" and "id" otherwise.
+// If data is a resource (see jsonapi.IsResource), names in "fields[type]" are
+// validated against attributes and relationships of the resource type, and
+// unknown names are rejected with E400Fields.
+//
+// Empty value like "fields[type]=" means no field is requested, so only "type"
+// and "id" are kept.
+//
+// Handlers can use FieldsFrom to skip computing unneeded fields:
+//
+//	fs := apitool.FieldsFrom(r.R().Context())
+//	if fs.Has("author.avatar") {
+//	    ret.Author.Avatar = loadAvatar(ret.Author.ID)
+//	}
+//
+// Errors, ASIS responses and files are not modified.
+func SparseFields(h jsonapi.Handler) jsonapi.Handler {
+	return func(r jsonapi.Request) (interface{}, error) {
+		fs, err := ParseFields(r.R().URL.Query())
+		if err != nil {
+			return nil, err
+		}
+		if fs == nil {
+			return h(r)
+		}
+
+		r = r.WithValue(fieldSetKey{}, fs)
+		res, err := h(r)
+		if err != nil {
+			return res, err
+		}
+		_, _, data := jsonapi.UnwrapResult(res)
+		if jsonapi.IsFile(data) {
+			return res, nil
+		}
+		if path := fs.validateTypes(data); path != "" {
+			return nil, E400Fields.SetData("unknown field: " + path)
+		}
+		if data, err = jsonapi.ResourceDocument(r.R(), data); err != nil {
+			return nil, err
+		}
+		if data, err = fs.Apply(data); err != nil {
+			return nil, err
+		}
+
+		switch x := res.(type) {
+		case jsonapi.Result:
+			return x.SetData(data), nil
+		case *jsonapi.Result:
+			if x != nil {
+				return x.SetData(data), nil
+			}
+		}
+		return data, nil
+	}
+}
@@ -0,0 +1,156 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/raohwork/jsonapi"
+)
+
+type fieldsAuthor struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type fieldsArticle struct {
+	ID      int          `json:"id"`
+	Title   string       `json:"title"`
+	Body    string       `json:"body"`
+	Author  fieldsAuthor `json:"author"`
+	Secret  string       `json:"-"`
+	Comment []fieldsAuthor
+}
+
+func TestSparseFields(t *testing.T) {
+	var avatar bool
+	h := SparseFields(func(r jsonapi.Request) (interface{}, error) {
+		avatar = FieldsFrom(r.R().Context()).Has("author.avatar")
+		a := fieldsArticle{
+			ID: 1, Title: "t", Body: "b",
+			Author:  fieldsAuthor{ID: 2, Name: "n", Avatar: "a"},
+			Comment: []fieldsAuthor{{ID: 3, Name: "c"}},
+		}
+		return jsonapi.Document{Data: []fieldsArticle{a}}, nil
+	})
+
+	cases := []struct {
+		query  string
+		avatar bool
+		expect string
+	}{
+		{"", true, `{"data":[{"id":1,"title":"t","body":"b","author":{"id":2,"name":"n","avatar":"a"},"Comment":[{"id":3,"name":"c"}]}]}`},
+		{"fields=title,author.name", false, `{"data":[{"author":{"name":"n"},"title":"t"}]}`},
+		{"fields=author,Comment.id", true, `{"data":[{"Comment":[{"id":3}],"author":{"avatar":"a","id":2,"name":"n"}}]}`},
+		{"fields=author.avatar", true, `{"data":[{"author":{"avatar":"a"}}]}`},
+		{"fields=Secret", false, `{"errors":[{"detail":"unknown field: Secret"}]}`},
+		{"fields=author.x", false, `{"errors":[{"detail":"unknown field: author.x"}]}`},
+		{"fields=a,,b", false, `{"errors":[{"detail":"invalid fields parameter"}]}`},
+		{"fields=", false, `{"data":[{}]}`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			avatar = false
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/?"+c.query, nil))
+			if actual := strings.TrimSpace(w.Body.String()); actual != c.expect {
+				t.Errorf("expected %s, got %s", c.expect, actual)
+			}
+			if avatar != c.avatar {
+				t.Errorf("expected Has() to be %v, got %v", c.avatar, avatar)
+			}
+		})
+	}
+}
+
+func TestSparseFieldsTyped(t *testing.T) {
+	h := SparseFields(func(r jsonapi.Request) (interface{}, error) {
+		return map[string]interface{}{
+			"type": "articles", "id": "1",
+			"attributes": map[string]interface{}{"title": "t", "body": "b"},
+			"author": map[string]interface{}{
+				"type": "people", "id": "2", "name": "n", "age": 3,
+			},
+		}, nil
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?fields[articles]=title&fields[people]=age", nil))
+	expect := `{"data":{"attributes":{"title":"t"},"author":{"age":3,"id":"2","type":"people"},"id":"1","type":"articles"}}`
+	if actual := strings.TrimSpace(w.Body.String()); actual != expect {
+		t.Errorf("expected %s, got %s", expect, actual)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?fields[articles]=&fields[people]=", nil))
+	expect = `{"data":{"attributes":{},"author":{"id":"2","type":"people"},"id":"1","type":"articles"}}`
+	if actual := strings.TrimSpace(w.Body.String()); actual != expect {
+		t.Errorf("expected %s, got %s", expect, actual)
+	}
+}
+
+type fieldsPerson struct {
+	ID   string `jsonapi:"primary,people"`
+	Name string `jsonapi:"attr,name"`
+}
+
+type fieldsPost struct {
+	ID     string        `jsonapi:"primary,posts"`
+	Title  string        `jsonapi:"attr,title"`
+	Body   string        `jsonapi:"attr,body,omitempty"`
+	Author *fieldsPerson `jsonapi:"relation,author"`
+}
+
+func TestSparseFieldsResource(t *testing.T) {
+	h := SparseFields(func(r jsonapi.Request) (interface{}, error) {
+		return &fieldsPost{
+			ID: "1", Title: "t",
+			Author: &fieldsPerson{ID: "2", Name: "n"},
+		}, nil
+	})
+
+	cases := []struct {
+		name   string
+		query  string
+		status int
+		expect string
+	}{
+		{
+			name:   "ok",
+			query:  "fields[posts]=title,author&fields[people]=name",
+			status: 200,
+			expect: `{"data":{"attributes":{"title":"t"},"id":"1","relationships":{"author":{"data":{"id":"2","type":"people"}}},"type":"posts"}}`,
+		},
+		{
+			name:   "unknown",
+			query:  "fields[posts]=title,nope",
+			status: 400,
+			expect: `{"errors":[{"detail":"unknown field: posts.nope"}]}`,
+		},
+		{
+			name:   "unknown-related",
+			query:  "fields[people]=age",
+			status: 400,
+			expect: `{"errors":[{"detail":"unknown field: people.age"}]}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/?"+c.query, nil))
+			if w.Code != c.status {
+				t.Errorf("expected status %d, got %d", c.status, w.Code)
+			}
+			if actual := strings.TrimSpace(w.Body.String()); actual != c.expect {
+				t.Errorf("expected %s, got %s", c.expect, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+	"github.com/raohwork/jsonapi/apitool/callapi"
+)
+
+// HealthCheck reports health of a component by returning nil or an error
+type HealthCheck func(ctx context.Context) error
+
+// Check is a named health check registered to Health
+type Check struct {
+	Name  string
+	Check HealthCheck
+	// defaults to Health.Timeout
+	Timeout time.Duration
+	// service is not ready if a critical check fails; failure of other checks
+	// only degrades the service
+	Critical bool
+	// also runs in liveness endpoint, use it carefully since failed liveness
+	// usually restarts the process
+	Liveness bool
+}
+
+// Health statuses
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+	HealthShutdown = "shutting down"
+)
+
+// CheckResult is result of a Check
+type CheckResult struct {
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Critical bool    `json:"critical"`
+	Duration float64 `json:"duration_ms"`
+}
+
+// HealthReport is the data returned by health endpoints
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Time   time.Time              `json:"time"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Health is a registry of health checks, which provides liveness and readiness
+// endpoints. Zero value is ready to use.
+//
+//	health := &apitool.Health{}
+//	health.Register(apitool.Check{
+//	    Name:     "db",
+//	    Check:    apitool.DBCheck(db),
+//	    Critical: true,
+//	})
+//	jsonapi.Register(mux, []jsonapi.API{
+//	    {Pattern: "/healthz", Handler: health.Liveness},
+//	    {Pattern: "/readyz", Handler: health.Readiness},
+//	})
+//
+// Readiness endpoint replies 200 if all critical checks pass, 503 otherwise.
+// The report is sent in both case.
+type Health struct {
+	// how long readiness results are cached, defaults to 1 second
+	TTL time.Duration
+	// default timeout of checks, defaults to 5 seconds
+	Timeout time.Duration
+
+	shutdown atomic.Bool
+	lock     sync.Mutex
+	checks   []Check
+	// protects cache, and coalesces concurrent readiness requests
+	runLock  sync.Mutex
+	cached   HealthReport
+	cachedAt time.Time
+}
+
+// Register adds a check. Check with same name is replaced.
+func (h *Health) Register(c Check) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for idx, x := range h.checks {
+		if x.Name == c.Name {
+			h.checks[idx] = c
+			return
+		}
+	}
+	h.checks = append(h.checks, c)
+}
+
+// SetReady flips readiness, readiness endpoint replies 503 without running
+// any check if ready is false. Call SetReady(false) at the beginning of
+// graceful shutdown, so load balancer can stop sending requests to this
+// instance.
+func (h *Health) SetReady(ready bool) {
+	h.shutdown.Store(!ready)
+}
+
+func (h *Health) timeout(c Check) time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	return 5 * time.Second
+}
+
+func (h *Health) runOne(ctx context.Context, c Check) (ret CheckResult) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout(c))
+	defer cancel()
+
+	begin := time.Now()
+	ch := make(chan error, 1)
+	go func() { ch <- c.Check(ctx) }()
+
+	var err error
+	select {
+	case err = <-ch:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	ret = CheckResult{
+		Status:   HealthOK,
+		Critical: c.Critical,
+		Duration: float64(time.Since(begin).Microseconds()) / 1000,
+	}
+	if err != nil {
+		ret.Status, ret.Error = HealthDown, err.Error()
+	}
+	return
+}
+
+// Run runs checks concurrently, only checks with Liveness set are executed if
+// liveness is true
+func (h *Health) Run(ctx context.Context, liveness bool) HealthReport {
+	h.lock.Lock()
+	checks := make([]Check, 0, len(h.checks))
+	for _, c := range h.checks {
+		if !liveness || c.Liveness {
+			checks = append(checks, c)
+		}
+	}
+	h.lock.Unlock()
+
+	results := make([]CheckResult, len(checks))
+	wg := &sync.WaitGroup{}
+	for idx, c := range checks {
+		wg.Add(1)
+		go func(idx int, c Check) {
+			defer wg.Done()
+			results[idx] = h.runOne(ctx, c)
+		}(idx, c)
+	}
+	wg.Wait()
+
+	ret := HealthReport{
+		Status: HealthOK,
+		Time:   time.Now(),
+		Checks: make(map[string]CheckResult, len(checks)),
+	}
+	for idx, c := range checks {
+		res := results[idx]
+		ret.Checks[c.Name] = res
+		if res.Status == HealthOK {
+			continue
+		}
+		if c.Critical || liveness {
+			ret.Status = HealthDown
+		} else if ret.Status == HealthOK {
+			ret.Status = HealthDegraded
+		}
+	}
+	return ret
+}
+
+func reportResult(rep HealthReport) interface{} {
+	if rep.Status == HealthOK || rep.Status == HealthDegraded {
+		return rep
+	}
+	return jsonapi.Status(http.StatusServiceUnavailable, rep)
+}
+
+// Liveness is a jsonapi.Handler reports whether the process is alive. Only
+// checks with Liveness set are executed, and results are not cached.
+func (h *Health) Liveness(r jsonapi.Request) (interface{}, error) {
+	r.W().Header().Set("Cache-Control", "no-store")
+	return reportResult(h.Run(r.R().Context(), true)), nil
+}
+
+// Readiness is a jsonapi.Handler reports whether the service is ready to
+// accept requests. Results are cached for TTL.
+func (h *Health) Readiness(r jsonapi.Request) (interface{}, error) {
+	r.W().Header().Set("Cache-Control", "no-store")
+	if h.shutdown.Load() {
+		return reportResult(HealthReport{
+			Status: HealthShutdown,
+			Time:   time.Now(),
+		}), nil
+	}
+
+	ttl := h.TTL
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+
+	h.runLock.Lock()
+	defer h.runLock.Unlock()
+	if h.cachedAt.IsZero() || time.Since(h.cachedAt) >= ttl {
+		// result is shared, so it must not be affected by client
+		h.cached = h.Run(context.WithoutCancel(r.R().Context()), false)
+		h.cachedAt = time.Now()
+	}
+	return reportResult(h.cached), nil
+}
+
+// DBCheck creates a HealthCheck pings the database
+func DBCheck(db *sql.DB) HealthCheck {
+	return db.PingContext
+}
+
+// UpstreamCheck creates a HealthCheck sends request with nil param by s, the
+// upstream is healthy if it replies status code less than 500
+//
+//	apitool.UpstreamCheck(callapi.NewEP("GET", "http://upstream/readyz").SendBy(nil))
+func UpstreamCheck(s callapi.Sender) HealthCheck {
+	return func(ctx context.Context) error {
+		resp, err := s(ctx, nil)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("upstream replies %s", resp.Status)
+		}
+		return nil
+	}
+}
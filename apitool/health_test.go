@@ -0,0 +1,92 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+	"github.com/raohwork/jsonapi/apitool/callapi"
+)
+
+func TestHealth(t *testing.T) {
+	var cnt int32
+	var critical, optional error
+
+	health := &Health{TTL: time.Hour, Timeout: 50 * time.Millisecond}
+	health.Register(Check{Name: "critical", Critical: true, Check: func(context.Context) error {
+		atomic.AddInt32(&cnt, 1)
+		return critical
+	}})
+	health.Register(Check{Name: "optional", Check: func(context.Context) error {
+		return optional
+	}})
+	health.Register(Check{Name: "slow", Liveness: true, Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	run := func(h jsonapi.Handler) (int, HealthReport) {
+		w := httptest.NewRecorder()
+		jsonapi.Handler(h).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		var resp struct{ Data HealthReport }
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("unexpected response: %s", w.Body.String())
+		}
+		return w.Code, resp.Data
+	}
+
+	optional = errors.New("degraded")
+	code, rep := run(health.Readiness)
+	if code != 200 || rep.Status != HealthDegraded || rep.Checks["slow"].Error != "context deadline exceeded" {
+		t.Fatalf("unexpected report: %d %+v", code, rep)
+	}
+
+	// cached
+	critical = errors.New("down")
+	if code, _ = run(health.Readiness); code != 200 || atomic.LoadInt32(&cnt) != 1 {
+		t.Fatalf("expected cached result, got %d after %d runs", code, cnt)
+	}
+
+	health.TTL = time.Nanosecond
+	code, rep = run(health.Readiness)
+	if code != 503 || rep.Status != HealthDown || rep.Checks["critical"].Error != "down" {
+		t.Fatalf("unexpected report: %d %+v", code, rep)
+	}
+
+	code, rep = run(health.Liveness)
+	if code != 503 || len(rep.Checks) != 1 {
+		t.Fatalf("unexpected liveness report: %d %+v", code, rep)
+	}
+
+	critical = nil
+	health.SetReady(false)
+	if code, rep = run(health.Readiness); code != 503 || rep.Status != HealthShutdown {
+		t.Fatalf("unexpected report: %d %+v", code, rep)
+	}
+}
+
+func TestUpstreamCheck(t *testing.T) {
+	down := &Health{}
+	down.SetReady(false)
+	server := httptest.NewServer(jsonapi.Handler(down.Readiness))
+	defer server.Close()
+
+	check := UpstreamCheck(callapi.NewEP("GET", server.URL).SendBy(nil))
+	if err := check(context.TODO()); err == nil {
+		t.Fatal("expected upstream to be unhealthy")
+	}
+
+	down.SetReady(true)
+	if err := check(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// IdempotencyRecord is the saved result of a request with Idempotency-Key
+type IdempotencyRecord struct {
+	// digest of method, url and body of the request
+	Fingerprint string
+	// false if the request is still in progress
+	Done bool
+	// http status code
+	Status int
+	// encoded data returned by handler
+	Data json.RawMessage
+	// true if handler returns a jsonapi.Document
+	Document bool
+	// response headers set by handler or jsonapi.Result, private headers
+	// like Set-Cookie are excluded
+	Header http.Header
+	// application-defined error code and message, if handler returns an error
+	ErrCode string
+	Detail  string
+}
+
+// IdempotencyStore saves results of requests
+//
+// Implement it to share results across processes, with redis or database for
+// example. Records should be kept for a reasonable time, 24 hours for example.
+type IdempotencyStore interface {
+	// Start atomically creates an in-progress record if key does not exist,
+	// or returns existing record and false
+	Start(ctx context.Context, key, fingerprint string) (rec IdempotencyRecord, created bool, err error)
+	// Finish saves the result
+	Finish(ctx context.Context, key string, rec IdempotencyRecord) error
+	// Abort removes the in-progress record, so client can retry later
+	Abort(ctx context.Context, key string) error
+}
+
+type idemEntry struct {
+	rec    IdempotencyRecord
+	expire time.Time
+}
+
+// MemoryIdempotencyStore is an in-memory IdempotencyStore. Zero value is ready
+// to use.
+type MemoryIdempotencyStore struct {
+	// how long a record is kept, defaults to 24 hours
+	TTL time.Duration
+
+	lock    sync.Mutex
+	entries map[string]idemEntry
+	ops     int
+}
+
+func (s *MemoryIdempotencyStore) ttl() time.Duration {
+	if s.TTL <= 0 {
+		return 24 * time.Hour
+	}
+	return s.TTL
+}
+
+// Start implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Start(_ context.Context, key, fingerprint string) (IdempotencyRecord, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if s.entries == nil {
+		s.entries = map[string]idemEntry{}
+	}
+	if s.ops++; s.ops >= 1024 {
+		s.ops = 0
+		for k, e := range s.entries {
+			if now.After(e.expire) {
+				delete(s.entries, k)
+			}
+		}
+	}
+
+	if e, ok := s.entries[key]; ok && now.Before(e.expire) {
+		return e.rec, false, nil
+	}
+
+	rec := IdempotencyRecord{Fingerprint: fingerprint}
+	s.entries[key] = idemEntry{rec: rec, expire: now.Add(s.ttl())}
+	return rec, true, nil
+}
+
+// Finish implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Finish(_ context.Context, key string, rec IdempotencyRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries[key] = idemEntry{rec: rec, expire: time.Now().Add(s.ttl())}
+	return nil
+}
+
+// Abort implements IdempotencyStore
+func (s *MemoryIdempotencyStore) Abort(_ context.Context, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+var (
+	// E409Idempotency indicates previous request with same key is in progress
+	E409Idempotency = jsonapi.E409.SetData("a request with same idempotency key is in progress")
+	// E422Idempotency indicates the key has been used with different request
+	E422Idempotency = jsonapi.E422.SetData("idempotency key has been used with different request")
+	// E400Idempotency indicates Idempotency-Key header is required
+	E400Idempotency = jsonapi.E400.SetData("Idempotency-Key header is required")
+)
+
+// Idempotency is a middleware to make unsafe requests safe to retry
+//
+// When a request carries Idempotency-Key header, the result is saved and
+// replayed if client sends same key again:
+//
+//   - Successful result and client errors (4xx) are saved. Server errors,
+//     redirects, files and ASIS responses are not, so client can retry them.
+//   - Returns E409Idempotency if previous request is still in progress.
+//   - Returns E422Idempotency if the key is reused with different request.
+//   - Replayed response has "Idempotent-Replayed: true" header, and headers
+//     set by handler except private ones like Set-Cookie, see Cache.
+//
+// Requests with safe methods (GET, HEAD, OPTIONS) are not affected.
+type Idempotency struct {
+	// defaults to a new MemoryIdempotencyStore for each Middleware call
+	Store IdempotencyStore
+	// returns E400Idempotency if the header is missing
+	Required bool
+	// separates keys of different clients, like api key or user id. Keys are
+	// global if nil.
+	Scope func(jsonapi.Request) string
+	// max size of request body, defaults to 1MB. Larger request is rejected
+	// with E413.
+	MaxBody int64
+}
+
+// fingerprint computes digest of method, path and body
+func fingerprint(r jsonapi.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.R().Method+"\x00"+r.R().URL.RequestURI()+"\x00")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (rec IdempotencyRecord) replay() (interface{}, error) {
+	if rec.Status >= 300 {
+		return nil, jsonapi.Error{Code: rec.Status}.
+			SetData(rec.Detail).
+			SetCode(rec.ErrCode)
+	}
+	if rec.ErrCode != "" || rec.Detail != "" {
+		return nil, jsonapi.APPERR.SetData(rec.Detail).SetCode(rec.ErrCode)
+	}
+	data := decodeResult(rec.Data, rec.Document)
+	if rec.Status == http.StatusOK && len(rec.Header) == 0 {
+		return data, nil
+	}
+
+	ret := jsonapi.Status(rec.Status, data)
+	for k, v := range rec.Header {
+		for _, x := range v {
+			ret = ret.Header(k, x)
+		}
+	}
+	return ret, nil
+}
+
+// Middleware is the *real* middleware part of Idempotency
+func (m Idempotency) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	// safe to set struct member as it is passed by value
+	if m.Store == nil {
+		m.Store = &MemoryIdempotencyStore{}
+	}
+	if m.MaxBody <= 0 {
+		m.MaxBody = 1 << 20
+	}
+
+	return func(r jsonapi.Request) (data interface{}, err error) {
+		switch r.R().Method {
+		case "GET", "HEAD", "OPTIONS":
+			return h(r)
+		}
+
+		key := r.R().Header.Get("Idempotency-Key")
+		if key == "" {
+			if m.Required {
+				return nil, E400Idempotency
+			}
+			return h(r)
+		}
+		if m.Scope != nil {
+			key = m.Scope(r) + "\x00" + key
+		}
+
+		body, e := io.ReadAll(io.LimitReader(r.R().Body, m.MaxBody+1))
+		if e != nil {
+			return nil, jsonapi.E400.SetOrigin(e)
+		}
+		if int64(len(body)) > m.MaxBody {
+			return nil, jsonapi.E413
+		}
+		r = jsonapi.ReplaceBody(r, io.NopCloser(bytes.NewReader(body)))
+		fp := fingerprint(r, body)
+
+		ctx := r.R().Context()
+		rec, created, e := m.Store.Start(ctx, key, fp)
+		if e != nil {
+			return nil, e
+		}
+		if !created {
+			switch {
+			case rec.Fingerprint != fp:
+				return nil, E422Idempotency
+			case !rec.Done:
+				return nil, E409Idempotency
+			}
+			r.W().Header().Set("Idempotent-Replayed", "true")
+			return rec.replay()
+		}
+
+		finished := false
+		defer func() {
+			if !finished {
+				m.Store.Abort(ctx, key)
+			}
+		}()
+		before := r.W().Header().Clone()
+		data, err = h(r)
+
+		rec = IdempotencyRecord{Fingerprint: fp, Done: true}
+		rec.Status, rec.ErrCode = statusOf(data, err)
+		if e, ok := err.(jsonapi.Error); ok {
+			if e.EqualTo(jsonapi.ASIS) {
+				return
+			}
+			rec.Detail = e.Data()
+		}
+		if rec.Status >= 500 || (rec.Status >= 300 && rec.Status < 400) {
+			return
+		}
+		if err == nil {
+			_, hdr, inner := jsonapi.UnwrapResult(data)
+			if rec.Data, rec.Document, e = encodeResult(r.R(), inner); e != nil {
+				return
+			}
+			rec.Header = changedHeader(r, before, r.W().Header())
+			for k, v := range changedHeader(r, nil, hdr) {
+				rec.Header[k] = v
+			}
+		}
+
+		finished = m.Store.Finish(ctx, key, rec) == nil
+		return
+	}
+}
@@ -0,0 +1,138 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestIdempotency(t *testing.T) {
+	cnt := 0
+	h := Idempotency{}.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		var p struct{ N int }
+		if err := r.Decode(&p); err != nil {
+			return nil, jsonapi.E400.SetOrigin(err)
+		}
+		cnt++
+		if p.N < 0 {
+			return nil, jsonapi.E404.SetCode("neg")
+		}
+		return p.N + cnt, nil
+	})
+	run := func(key, body string) (*httptest.ResponseRecorder, interface{}, error) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		data, err := h(jsonapi.FromHTTP(w, req))
+		return w, data, err
+	}
+
+	_, data, err := run("a", `{"N":1}`)
+	if err != nil || data != 2 {
+		t.Fatalf("unexpected result: %v, %v", data, err)
+	}
+
+	w, data, err := run("a", `{"N":1}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if raw, ok := data.(json.RawMessage); !ok || string(raw) != "2" {
+		t.Fatalf("expected replayed data, got %#v", data)
+	}
+	if w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected Idempotent-Replayed header")
+	}
+	if cnt != 1 {
+		t.Fatalf("handler should not be executed again, got %d", cnt)
+	}
+
+	_, _, err = run("a", `{"N":2}`)
+	if e, ok := err.(jsonapi.Error); !ok || !e.EqualTo(E422Idempotency) {
+		t.Fatalf("expected E422Idempotency, got %v", err)
+	}
+
+	run("b", `{"N":-1}`)
+	_, _, err = run("b", `{"N":-1}`)
+	if e, ok := err.(jsonapi.Error); !ok || e.Code != 404 || e.ErrCode() != "neg" {
+		t.Fatalf("expected replayed 404, got %v", err)
+	}
+
+	run("", `{"N":1}`)
+	run("", `{"N":1}`)
+	if cnt != 4 {
+		t.Fatalf("requests without key should not be affected, got %d", cnt)
+	}
+}
+
+func TestIdempotencyHeader(t *testing.T) {
+	h := Idempotency{}.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		r.W().Header().Set("Location", "/items/1")
+		r.W().Header().Set("Set-Cookie", "sid=secret")
+		return jsonapi.Status(201, 1).Header("X-Item", "1"), nil
+	})
+	run := func() (interface{}, error) {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("{}"))
+		req.Header.Set("Idempotency-Key", "a")
+		return h(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+	}
+
+	run()
+	data, err := run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status, hdr, _ := jsonapi.UnwrapResult(data)
+	if status != 201 {
+		t.Errorf("expected 201, got %d", status)
+	}
+	if x := hdr.Get("Location"); x != "/items/1" {
+		t.Errorf("expected Location to be replayed, got %q", x)
+	}
+	if x := hdr.Get("X-Item"); x != "1" {
+		t.Errorf("expected X-Item to be replayed, got %q", x)
+	}
+	if x := hdr.Get("Set-Cookie"); x != "" {
+		t.Errorf("Set-Cookie should not be replayed, got %q", x)
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	store := &MemoryIdempotencyStore{}
+	h := Idempotency{Store: store}.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		return nil, nil
+	})
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("{}"))
+	req.Header.Set("Idempotency-Key", "a")
+	store.Start(req.Context(), "a", fingerprint(jsonapi.FromHTTP(nil, req), []byte("{}")))
+
+	_, err := h(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+	if e, ok := err.(jsonapi.Error); !ok || !e.EqualTo(E409Idempotency) {
+		t.Fatalf("expected E409Idempotency, got %v", err)
+	}
+}
+
+func TestIdempotencyStatus(t *testing.T) {
+	h := jsonapi.Handler(Idempotency{}.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		return jsonapi.Status(201, 1).Header("Location", "/a/1"), nil
+	}))
+
+	for x := 0; x < 2; x++ {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "a")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != 201 || w.Header().Get("Location") != "/a/1" || strings.TrimSpace(w.Body.String()) != `{"data":1}` {
+			t.Fatalf("#%d: unexpected response: %d %v %s", x, w.Code, w.Header(), w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,464 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// E401JWT is a predefined error indicates the bearer token is missing or
+// invalid. Detailed reason is sent in WWW-Authenticate header.
+var E401JWT = jsonapi.E401.SetData("invalid or missing bearer token")
+
+// Errors returned by JWTAuth.Verify
+var (
+	ErrJWTMalformed   = errors.New("malformed token")
+	ErrJWTAlgorithm   = errors.New("unsupported algorithm")
+	ErrJWTSignature   = errors.New("invalid signature")
+	ErrJWTExpired     = errors.New("token is expired")
+	ErrJWTNotValidYet = errors.New("token is not valid yet")
+	ErrJWTIssuer      = errors.New("invalid issuer")
+	ErrJWTAudience    = errors.New("invalid audience")
+)
+
+// JWTAudience is the "aud" claim, which can be a string or an array of strings
+type JWTAudience []string
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *JWTAudience) UnmarshalJSON(buf []byte) error {
+	var s string
+	if err := json.Unmarshal(buf, &s); err == nil {
+		*a = JWTAudience{s}
+		return nil
+	}
+	return json.Unmarshal(buf, (*[]string)(a))
+}
+
+// JWTNumericDate is seconds since unix epoch, used by "exp", "nbf" and "iat"
+// claims. Non-integer values allowed by RFC 7519 are truncated.
+type JWTNumericDate int64
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *JWTNumericDate) UnmarshalJSON(buf []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(buf, &n); err != nil {
+		return err
+	}
+	if x, err := n.Int64(); err == nil {
+		*d = JWTNumericDate(x)
+		return nil
+	}
+	x, err := n.Float64()
+	if err != nil {
+		return err
+	}
+	*d = JWTNumericDate(math.Floor(x))
+	return nil
+}
+
+// Time converts d to time.Time
+func (d JWTNumericDate) Time() time.Time {
+	return time.Unix(int64(d), 0)
+}
+
+// JWTClaims is registered claims defined in RFC 7519. Embed it in your claims
+// type to access them.
+type JWTClaims struct {
+	Issuer    string         `json:"iss,omitempty"`
+	Subject   string         `json:"sub,omitempty"`
+	Audience  JWTAudience    `json:"aud,omitempty"`
+	ExpiresAt JWTNumericDate `json:"exp,omitempty"`
+	NotBefore JWTNumericDate `json:"nbf,omitempty"`
+	IssuedAt  JWTNumericDate `json:"iat,omitempty"`
+	ID        string         `json:"jti,omitempty"`
+}
+
+// JWTKeyFunc finds the key to verify a token by the "alg" and "kid" header.
+// Returned key must be []byte for HS256/384/512, *rsa.PublicKey for RS256 or
+// *ecdsa.PublicKey for ES256.
+type JWTKeyFunc func(ctx context.Context, alg, kid string) (interface{}, error)
+
+// JWTAuth is a middleware authenticates clients with JWT in Authorization
+// header. Supported algorithms are HS256, HS384, HS512, RS256 and ES256.
+//
+// Claims are decoded into C and saved in context, use JWTClaimsFrom to
+// retrieve it.
+//
+//	type MyClaims struct {
+//	    apitool.JWTClaims
+//	    Roles []string `json:"roles"`
+//	}
+//
+//	keys, err := apitool.LoadJWKS("/etc/myapp/jwks.json")
+//	auth := apitool.JWTAuth[MyClaims]{
+//	    Keys:     keys,
+//	    Issuer:   "https://auth.example.com",
+//	    Audience: "myapp",
+//	    Leeway:   time.Minute,
+//	}
+//	jsonapi.With(auth.Middleware).Register(mux, apis)
+//
+//	func myHandler(q jsonapi.Request) (interface{}, error) {
+//	    claims, _ := apitool.JWTClaimsFrom[MyClaims](q.R().Context())
+//	    ...
+//	}
+type JWTAuth[C any] struct {
+	// REQUIRED
+	Keys JWTKeyFunc
+	// allowed algorithms, defaults to all supported algorithms
+	Algorithms []string
+	// required "iss" claim, not checked if empty
+	Issuer string
+	// required value in "aud" claim, not checked if empty
+	Audience string
+	// allowed clock skew when validating "exp" and "nbf"
+	Leeway time.Duration
+	// realm in WWW-Authenticate header, optional
+	Realm string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"ES256": crypto.SHA256,
+}
+
+func newHash(h crypto.Hash) func() hash.Hash {
+	switch h {
+	case crypto.SHA384:
+		return sha512.New384
+	case crypto.SHA512:
+		return sha512.New
+	}
+	return sha256.New
+}
+
+func (m JWTAuth[C]) allowed(alg string) bool {
+	if _, ok := jwtHashes[alg]; !ok {
+		return false
+	}
+	if len(m.Algorithms) == 0 {
+		return true
+	}
+	for _, a := range m.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyJWTSignature(alg string, key interface{}, signed string, sig []byte) error {
+	h := jwtHashes[alg]
+	switch k := key.(type) {
+	case []byte:
+		if !strings.HasPrefix(alg, "HS") {
+			break
+		}
+		if len(k) == 0 {
+			// anyone can sign with empty key
+			return fmt.Errorf("%w: empty key", ErrJWTSignature)
+		}
+		mac := hmac.New(newHash(h), k)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrJWTSignature
+		}
+		return nil
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			break
+		}
+		sum := sha256.Sum256([]byte(signed))
+		if rsa.VerifyPKCS1v15(k, h, sum[:], sig) != nil {
+			return ErrJWTSignature
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || k.Curve != elliptic.P256() {
+			break
+		}
+		if len(sig) != 64 {
+			return ErrJWTSignature
+		}
+		sum := sha256.Sum256([]byte(signed))
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(k, sum[:], r, s) {
+			return ErrJWTSignature
+		}
+		return nil
+	}
+	// prevents algorithm confusion, HS256 signed with RSA public key for example
+	return fmt.Errorf("%w: key type %T cannot be used with %s", ErrJWTAlgorithm, key, alg)
+}
+
+func (m JWTAuth[C]) validate(c JWTClaims) error {
+	now := time.Now()
+	if c.ExpiresAt != 0 && !now.Before(c.ExpiresAt.Time().Add(m.Leeway)) {
+		return ErrJWTExpired
+	}
+	if c.NotBefore != 0 && now.Before(c.NotBefore.Time().Add(-m.Leeway)) {
+		return ErrJWTNotValidYet
+	}
+	if m.Issuer != "" && c.Issuer != m.Issuer {
+		return ErrJWTIssuer
+	}
+	if m.Audience == "" {
+		return nil
+	}
+	for _, aud := range c.Audience {
+		if aud == m.Audience {
+			return nil
+		}
+	}
+	return ErrJWTAudience
+}
+
+// Verify verifies the token and decodes the claims
+func (m JWTAuth[C]) Verify(ctx context.Context, token string) (ret C, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ret, ErrJWTMalformed
+	}
+	var hdr jwtHeader
+	buf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(buf, &hdr) != nil {
+		return ret, ErrJWTMalformed
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ret, ErrJWTMalformed
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ret, ErrJWTMalformed
+	}
+
+	if !m.allowed(hdr.Alg) {
+		return ret, ErrJWTAlgorithm
+	}
+	key, err := m.Keys(ctx, hdr.Alg, hdr.Kid)
+	if err != nil {
+		return
+	}
+	if err = verifyJWTSignature(hdr.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return
+	}
+
+	var std JWTClaims
+	if json.Unmarshal(payload, &std) != nil || json.Unmarshal(payload, &ret) != nil {
+		return ret, ErrJWTMalformed
+	}
+	err = m.validate(std)
+	return
+}
+
+type jwtClaimsKey struct{}
+
+// JWTClaimsFrom retrieves claims saved by JWTAuth[C]
+func JWTClaimsFrom[C any](ctx context.Context) (ret C, ok bool) {
+	ret, ok = ctx.Value(jwtClaimsKey{}).(C)
+	return
+}
+
+func (m JWTAuth[C]) challenge(w http.ResponseWriter, err error) error {
+	params := []string{}
+	if m.Realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", m.Realm))
+	}
+	if err != nil {
+		params = append(params,
+			`error="invalid_token"`,
+			fmt.Sprintf("error_description=%q", err.Error()),
+		)
+	}
+	v := "Bearer"
+	if len(params) > 0 {
+		v += " " + strings.Join(params, ", ")
+	}
+	w.Header().Set("WWW-Authenticate", v)
+	if err == nil {
+		return E401JWT
+	}
+	return E401JWT.SetOrigin(err)
+}
+
+// Middleware is the *real* middleware part of JWTAuth
+func (m JWTAuth[C]) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	return func(r jsonapi.Request) (interface{}, error) {
+		auth := r.R().Header.Get("Authorization")
+		scheme, token, _ := strings.Cut(auth, " ")
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return nil, m.challenge(r.W(), nil)
+		}
+
+		claims, err := m.Verify(r.R().Context(), strings.TrimSpace(token))
+		if err != nil {
+			return nil, m.challenge(r.W(), err)
+		}
+		return h(r.WithValue(jwtClaimsKey{}, claims))
+	}
+}
+
+// JWK is a JSON Web Key defined in RFC 7517, only fields needed to verify
+// supported algorithms are defined
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	// oct
+	K string `json:"k,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Key converts jwk to []byte, *rsa.PublicKey or *ecdsa.PublicKey
+func (k JWK) Key() (interface{}, error) {
+	b64 := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "oct":
+		key, err := b64(k.K)
+		if err == nil && len(key) == 0 {
+			err = errors.New("empty symmetric key")
+		}
+		return key, err
+	case "RSA":
+		n, err := b64(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() < 3 || exp.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := b64(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid EC point")
+		}
+		// validates the point is on the curve
+		if _, err = ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func (k JWK) usableFor(alg string) bool {
+	if k.Alg != "" {
+		return k.Alg == alg
+	}
+	switch k.Kty {
+	case "oct":
+		return strings.HasPrefix(alg, "HS")
+	case "RSA":
+		return alg == "RS256"
+	case "EC":
+		return alg == "ES256"
+	}
+	return false
+}
+
+// JWKS creates a JWTKeyFunc from a JSON Web Key Set like
+// {"keys": [{"kty": "oct", "kid": "1", "k": "..."}]}
+//
+// Keys are selected by "kid" if the token has one, or the first key usable for
+// the algorithm otherwise. Keys with "use" other than "sig" are ignored.
+func JWKS(buf []byte) (JWTKeyFunc, error) {
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.Unmarshal(buf, &set); err != nil {
+		return nil, err
+	}
+
+	type entry struct {
+		jwk JWK
+		key interface{}
+	}
+	keys := make([]entry, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.Key()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys = append(keys, entry{k, key})
+	}
+
+	return func(_ context.Context, alg, kid string) (interface{}, error) {
+		for _, k := range keys {
+			if (kid == "" || k.jwk.Kid == kid) && k.jwk.usableFor(alg) {
+				return k.key, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: no key for kid %q", ErrJWTSignature, kid)
+	}, nil
+}
+
+// LoadJWKS loads JSON Web Key Set from file, see JWKS
+func LoadJWKS(file string) (JWTKeyFunc, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return JWKS(buf)
+}
@@ -0,0 +1,227 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+	"github.com/raohwork/jsonapi/apitool/callapi"
+)
+
+type testClaims struct {
+	JWTClaims
+	Role string `json:"role"`
+}
+
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims interface{}) string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	hdr, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(hdr) + "." + b64(payload)
+	sum := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestJWTVerify(t *testing.T) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	secret := []byte("my secret")
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"oct","kid":"hs","k":"%s"},
+		{"kty":"RSA","kid":"rs","n":"%s","e":"AQAB"},
+		{"kty":"EC","kid":"es","crv":"P-256","x":"%s","y":"%s"},
+		{"kty":"RSA","kid":"enc","use":"enc","n":"%s","e":"AQAB"}
+	]}`,
+		b64(secret), b64(rsaKey.N.Bytes()),
+		b64(ecKey.X.FillBytes(make([]byte, 32))), b64(ecKey.Y.FillBytes(make([]byte, 32))),
+		b64(rsaKey.N.Bytes()),
+	)
+	keys, err := JWKS([]byte(jwks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := JWTAuth[testClaims]{
+		Keys:     keys,
+		Issuer:   "me",
+		Audience: "you",
+		Leeway:   time.Minute,
+	}
+
+	now := JWTNumericDate(time.Now().Unix())
+	valid := testClaims{
+		JWTClaims: JWTClaims{Issuer: "me", Audience: JWTAudience{"x", "you"}, ExpiresAt: now + 60},
+		Role:      "admin",
+	}
+	claims := func(f func(*testClaims)) testClaims {
+		ret := valid
+		f(&ret)
+		return ret
+	}
+	// aud in string form
+	single := map[string]interface{}{"iss": "me", "aud": "you", "role": "user"}
+
+	cases := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"HS256", signJWT(t, "HS256", "hs", secret, valid), nil},
+		{"RS256", signJWT(t, "RS256", "rs", rsaKey, valid), nil},
+		{"ES256", signJWT(t, "ES256", "es", ecKey, valid), nil},
+		{"no-kid", signJWT(t, "ES256", "", ecKey, valid), nil},
+		{"string-aud", signJWT(t, "HS256", "hs", secret, single), nil},
+		{"in-leeway", signJWT(t, "HS256", "hs", secret, claims(func(c *testClaims) {
+			c.ExpiresAt, c.NotBefore = now-30, now+30
+		})), nil},
+		{"expired", signJWT(t, "HS256", "hs", secret, claims(func(c *testClaims) {
+			c.ExpiresAt = now - 61
+		})), ErrJWTExpired},
+		{"not-yet", signJWT(t, "HS256", "hs", secret, claims(func(c *testClaims) {
+			c.NotBefore = now + 61
+		})), ErrJWTNotValidYet},
+		{"issuer", signJWT(t, "HS256", "hs", secret, claims(func(c *testClaims) {
+			c.Issuer = "other"
+		})), ErrJWTIssuer},
+		{"audience", signJWT(t, "HS256", "hs", secret, claims(func(c *testClaims) {
+			c.Audience = JWTAudience{"other"}
+		})), ErrJWTAudience},
+		{"fractional", signJWT(t, "HS256", "hs", secret, map[string]interface{}{
+			"iss": "me", "aud": "you", "exp": float64(now) + 60.5, "nbf": float64(now) - 0.5,
+		}), nil},
+		{"signature", signJWT(t, "HS256", "hs", []byte("wrong"), valid), ErrJWTSignature},
+		{"wrong-kid", signJWT(t, "RS256", "es", rsaKey, valid), ErrJWTSignature},
+		{"enc-key", signJWT(t, "RS256", "enc", rsaKey, valid), ErrJWTSignature},
+		{"none", signJWT(t, "none", "", nil, valid), ErrJWTAlgorithm},
+		{"malformed", "a.b", ErrJWTMalformed},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := auth.Verify(context.TODO(), c.token)
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expected %v, got %v", c.err, err)
+			}
+		})
+	}
+
+	t.Run("empty-key", func(t *testing.T) {
+		a := auth
+		a.Keys = func(context.Context, string, string) (interface{}, error) {
+			return []byte{}, nil
+		}
+		_, err := a.Verify(context.TODO(), signJWT(t, "HS256", "", []byte{}, valid))
+		if !errors.Is(err, ErrJWTSignature) {
+			t.Fatalf("expected signature error, got %v", err)
+		}
+		if _, err = JWKS([]byte(`{"keys":[{"kty":"oct","kid":"x"}]}`)); err == nil {
+			t.Fatal("expected empty symmetric key to be rejected")
+		}
+	})
+
+	t.Run("confusion", func(t *testing.T) {
+		// HS256 signed with RSA public key
+		a := auth
+		a.Keys = func(context.Context, string, string) (interface{}, error) {
+			return &rsaKey.PublicKey, nil
+		}
+		_, err := a.Verify(context.TODO(), signJWT(t, "HS256", "", rsaKey.N.Bytes(), valid))
+		if !errors.Is(err, ErrJWTAlgorithm) {
+			t.Fatalf("expected algorithm error, got %v", err)
+		}
+	})
+}
+
+func TestJWTMiddleware(t *testing.T) {
+	secret := []byte("my secret")
+	auth := JWTAuth[testClaims]{
+		Keys: func(_ context.Context, alg, kid string) (interface{}, error) {
+			return secret, nil
+		},
+		Algorithms: []string{"HS256"},
+		Realm:      "api",
+	}
+	h := jsonapi.Handler(auth.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		c, ok := JWTClaimsFrom[testClaims](r.R().Context())
+		if !ok {
+			return nil, errors.New("no claims")
+		}
+		return c.Role, nil
+	}))
+
+	token := signJWT(t, "HS256", "", secret, map[string]interface{}{"role": "admin"})
+	expired := signJWT(t, "HS256", "", secret, map[string]interface{}{"exp": 1})
+	cases := []struct {
+		name   string
+		token  callapi.TokenSource
+		code   int
+		body   string
+		header string
+	}{
+		{"ok", callapi.StaticToken(token), 200, `{"data":"admin"}`, ""},
+		{"missing", nil, 401, "", `Bearer realm="api"`},
+		{"expired", callapi.StaticToken(expired), 401, "",
+			`Bearer realm="api", error="invalid_token", error_description="token is expired"`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if c.token != nil {
+				req, _ = callapi.BearerToken(c.token)(req)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != c.code {
+				t.Fatalf("expected %d, got %d: %s", c.code, w.Code, w.Body.String())
+			}
+			if c.body != "" && strings.TrimSpace(w.Body.String()) != c.body {
+				t.Errorf("unexpected body: %s", w.Body.String())
+			}
+			if x := w.Header().Get("WWW-Authenticate"); x != c.header {
+				t.Errorf("unexpected WWW-Authenticate: %s", x)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// MetricsRecord represents a finished request observed by Metrics middleware
+type MetricsRecord struct {
+	// pattern of the API, see jsonapi.PatternOf
+	Pattern string
+	Method  string
+	// http status code, derived from returned error
+	Status int
+	// result of jsonapi.Error.ErrCode(), empty if not a jsonapi.Error
+	ErrCode string
+	Elapsed time.Duration
+}
+
+// MetricsCollector receives observations from Metrics middleware
+//
+// Implement it to bridge metrics to other monitoring systems. Both methods
+// might be called concurrently.
+type MetricsCollector interface {
+	// Begin is called before the handler runs
+	Begin(pattern, method string)
+	// End is called after the handler returns, or panics with status 500
+	End(rec MetricsRecord)
+}
+
+// statusRecorder remembers the status code written by handlers using ASIS
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Metrics creates a middleware which reports every request to c
+//
+// Requests are labelled by the pattern of the API, so you should register your
+// handler with jsonapi.Register, jsonapi.RegisterAll or a Registerer.
+func Metrics(c MetricsCollector) jsonapi.Middleware {
+	return func(h jsonapi.Handler) jsonapi.Handler {
+		return func(r jsonapi.Request) (data interface{}, err error) {
+			pattern, method := jsonapi.PatternOf(r.R()), r.R().Method
+			c.Begin(pattern, method)
+
+			rec := &statusRecorder{ResponseWriter: r.W()}
+			begin := time.Now()
+			// End must be called even if handler panics, or in-flight
+			// requests leak
+			panicked := true
+			defer func() {
+				elapsed := time.Since(begin)
+				status, code := statusOf(data, err)
+				if panicked {
+					status, code = http.StatusInternalServerError, ""
+				}
+				if rec.code != 0 {
+					status = rec.code
+				}
+				c.End(MetricsRecord{
+					Pattern: pattern,
+					Method:  method,
+					Status:  status,
+					ErrCode: code,
+					Elapsed: elapsed,
+				})
+			}()
+			data, err = h(jsonapi.WrapResponse(r, rec))
+			panicked = false
+			return
+		}
+	}
+}
+
+// DefaultBuckets is default histogram buckets (in seconds) used by PromMetrics
+var DefaultBuckets = []float64{
+	.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10,
+}
+
+type promRoute struct {
+	pattern string
+	method  string
+}
+
+type promKey struct {
+	promRoute
+	status int
+	code   string
+}
+
+type promSeries struct {
+	count   uint64
+	sum     float64
+	buckets []uint64
+}
+
+// PromMetrics is a MetricsCollector keeps metrics in memory, and serves them in
+// Prometheus text exposition format. Zero value is ready to use.
+//
+// Exported metrics are (with default namespace):
+//
+//   - jsonapi_requests_total: counter of finished requests
+//   - jsonapi_request_duration_seconds: histogram of latency
+//   - jsonapi_requests_in_flight: gauge of running requests
+//
+// First two metrics are labelled by pattern, method, status and code, the last
+// one is labelled only by pattern and method.
+type PromMetrics struct {
+	// prefix of metric names, defaults to "jsonapi"
+	Namespace string
+	// upper bounds of histogram buckets in ascending order, defaults to
+	// DefaultBuckets. Changing it after first request is not supported.
+	Buckets []float64
+
+	lock     sync.Mutex
+	inflight map[promRoute]int64
+	series   map[promKey]*promSeries
+}
+
+func (m *PromMetrics) buckets() []float64 {
+	if m.Buckets == nil {
+		return DefaultBuckets
+	}
+	return m.Buckets
+}
+
+// Begin implements MetricsCollector
+func (m *PromMetrics) Begin(pattern, method string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.inflight == nil {
+		m.inflight = map[promRoute]int64{}
+	}
+	m.inflight[promRoute{pattern, method}]++
+}
+
+// End implements MetricsCollector
+func (m *PromMetrics) End(rec MetricsRecord) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	route := promRoute{rec.Pattern, rec.Method}
+	if m.inflight != nil && m.inflight[route] > 0 {
+		m.inflight[route]--
+	}
+
+	if m.series == nil {
+		m.series = map[promKey]*promSeries{}
+	}
+	key := promKey{route, rec.Status, rec.ErrCode}
+	s, ok := m.series[key]
+	if !ok {
+		s = &promSeries{buckets: make([]uint64, len(m.buckets()))}
+		m.series[key] = s
+	}
+
+	sec := rec.Elapsed.Seconds()
+	s.count++
+	s.sum += sec
+	for idx, le := range m.buckets() {
+		if sec <= le {
+			s.buckets[idx]++
+		}
+	}
+}
+
+// promEscape escapes label value according to the exposition format
+var promEscape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (r promRoute) labels() string {
+	return fmt.Sprintf(
+		`pattern="%s",method="%s"`,
+		promEscape.Replace(r.pattern), promEscape.Replace(r.method),
+	)
+}
+
+func (k promKey) labels() string {
+	return fmt.Sprintf(
+		`%s,status="%d",code="%s"`,
+		k.promRoute.labels(), k.status, promEscape.Replace(k.code),
+	)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// WriteTo writes all metrics to w in Prometheus text exposition format
+func (m *PromMetrics) WriteTo(w io.Writer) (n int64, err error) {
+	ns := m.Namespace
+	if ns == "" {
+		ns = "jsonapi"
+	}
+
+	m.lock.Lock()
+	keys := make([]promKey, 0, len(m.series))
+	series := make(map[promKey]promSeries, len(m.series))
+	for k, s := range m.series {
+		keys = append(keys, k)
+		series[k] = promSeries{
+			count:   s.count,
+			sum:     s.sum,
+			buckets: append([]uint64(nil), s.buckets...),
+		}
+	}
+	routes := make([]promRoute, 0, len(m.inflight))
+	inflight := make(map[promRoute]int64, len(m.inflight))
+	for k, v := range m.inflight {
+		routes = append(routes, k)
+		inflight[k] = v
+	}
+	m.lock.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].labels() < keys[j].labels() })
+	sort.Slice(routes, func(i, j int) bool { return routes[i].labels() < routes[j].labels() })
+
+	buf := &strings.Builder{}
+	name := ns + "_requests_total"
+	fmt.Fprintf(buf, "# HELP %s Total number of finished requests.\n", name)
+	fmt.Fprintf(buf, "# TYPE %s counter\n", name)
+	for _, k := range keys {
+		fmt.Fprintf(buf, "%s{%s} %d\n", name, k.labels(), series[k].count)
+	}
+
+	name = ns + "_request_duration_seconds"
+	fmt.Fprintf(buf, "# HELP %s Latency of requests in seconds.\n", name)
+	fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+	for _, k := range keys {
+		s, l := series[k], k.labels()
+		for idx, le := range m.buckets() {
+			fmt.Fprintf(
+				buf, "%s_bucket{%s,le=\"%s\"} %d\n",
+				name, l, formatFloat(le), s.buckets[idx],
+			)
+		}
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, l, s.count)
+		fmt.Fprintf(buf, "%s_sum{%s} %s\n", name, l, formatFloat(s.sum))
+		fmt.Fprintf(buf, "%s_count{%s} %d\n", name, l, s.count)
+	}
+
+	name = ns + "_requests_in_flight"
+	fmt.Fprintf(buf, "# HELP %s Number of running requests.\n", name)
+	fmt.Fprintf(buf, "# TYPE %s gauge\n", name)
+	for _, r := range routes {
+		fmt.Fprintf(buf, "%s{%s} %d\n", name, r.labels(), inflight[r])
+	}
+
+	x, err := io.WriteString(w, buf.String())
+	return int64(x), err
+}
+
+// ServeHTTP implements http.Handler, so you can expose metrics like
+//
+//	metrics := &apitool.PromMetrics{}
+//	http.Handle("/metrics", metrics)
+//	jsonapi.With(apitool.Metrics(metrics)).Register(http.DefaultServeMux, apis)
+func (m *PromMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
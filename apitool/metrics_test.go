@@ -0,0 +1,74 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestMetrics(t *testing.T) {
+	m := &PromMetrics{Buckets: []float64{1}}
+	mux := http.NewServeMux()
+	jsonapi.With(Metrics(m)).Register(mux, []jsonapi.API{
+		{Pattern: "/ok", Handler: func(r jsonapi.Request) (interface{}, error) {
+			return 1, nil
+		}},
+		{Pattern: "/fail", Handler: func(r jsonapi.Request) (interface{}, error) {
+			return nil, jsonapi.E404.SetCode("nope")
+		}},
+		{Pattern: "/err", Handler: func(r jsonapi.Request) (interface{}, error) {
+			return nil, errors.New("oops")
+		}},
+		{Pattern: "/panic", Handler: func(r jsonapi.Request) (interface{}, error) {
+			panic("oops")
+		}},
+	})
+
+	for _, p := range []string{"/ok", "/ok", "/fail", "/err", "/panic"} {
+		func() {
+			defer func() { recover() }()
+			mux.ServeHTTP(
+				httptest.NewRecorder(),
+				httptest.NewRequest("GET", p, nil),
+			)
+		}()
+	}
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	out := w.Body.String()
+
+	expects := []string{
+		`jsonapi_requests_total{pattern="/ok",method="GET",status="200",code=""} 2`,
+		`jsonapi_requests_total{pattern="/fail",method="GET",status="404",code="nope"} 1`,
+		`jsonapi_requests_total{pattern="/err",method="GET",status="500",code=""} 1`,
+		`jsonapi_request_duration_seconds_bucket{pattern="/ok",method="GET",status="200",code="",le="1"} 2`,
+		`jsonapi_request_duration_seconds_bucket{pattern="/ok",method="GET",status="200",code="",le="+Inf"} 2`,
+		`jsonapi_request_duration_seconds_count{pattern="/ok",method="GET",status="200",code=""} 2`,
+		`jsonapi_requests_in_flight{pattern="/ok",method="GET"} 0`,
+		`jsonapi_requests_total{pattern="/panic",method="GET",status="500",code=""} 1`,
+		`jsonapi_requests_in_flight{pattern="/panic",method="GET"} 0`,
+		"# TYPE jsonapi_request_duration_seconds histogram",
+	}
+	for _, e := range expects {
+		if !strings.Contains(out, e+"\n") {
+			t.Errorf("expected %s in output, got:\n%s", e, out)
+		}
+	}
+}
+
+func TestPromEscape(t *testing.T) {
+	k := promRoute{pattern: "a\"b\\c\nd", method: "GET"}
+	expect := `pattern="a\"b\\c\nd",method="GET"`
+	if actual := k.labels(); actual != expect {
+		t.Fatalf("expected %s, got %s", expect, actual)
+	}
+}
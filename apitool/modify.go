@@ -25,6 +25,9 @@ func setHeaderIfEmpty(r jsonapi.Request, key, val string) {
 //
 // It's no-op if handler returns any error.
 //
+// As described in RFC 9110, If-Modified-Since is ignored when request has
+// If-None-Match header, see ETag.
+//
 //	// If browser send If-Modified-Since >= dateA, 304 is returned, "hello" otherwise.
 //	//
 //	// If browser send If-Modified-Since < dateA, "hello" is always replied.
@@ -58,7 +61,7 @@ func LastModify(h jsonapi.Handler) (ret jsonapi.Handler) {
 
 		// check if request header is set
 		txt = r.R().Header.Get("If-Modified-Since")
-		if txt != "" {
+		if txt != "" && r.R().Header.Get("If-None-Match") == "" {
 			want, e := http.ParseTime(txt)
 			if e == nil {
 				if !has.After(want) {
@@ -0,0 +1,298 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// E400Page indicates pagination parameters are invalid
+var E400Page = jsonapi.E400.SetData("invalid pagination parameter")
+
+// ErrCursor indicates the cursor token is malformed or tampered
+var ErrCursor = errors.New("invalid cursor")
+
+// ErrCursorSecret indicates the secret to sign cursor tokens is empty
+var ErrCursorSecret = errors.New("secret of cursor is not set")
+
+// Paginator parses pagination parameters from query string
+//
+// Offset-style parameters are "limit" and "offset", or "limit" and "page"
+// (starts from 1). Cursor-style parameters are "limit" and "cursor".
+//
+//	var pager = apitool.Paginator{MaxLimit: 50, Secret: mySecret}
+//
+//	func listArticles(r jsonapi.Request) (interface{}, error) {
+//	    p, err := pager.Parse(r)
+//	    if err != nil {
+//	        return nil, err
+//	    }
+//	    var c struct {
+//	        ID     int64 // id of first/last article in previous page
+//	        Before bool  // true to load articles before ID
+//	    }
+//	    if _, err = p.Cursor(&c); err != nil {
+//	        return nil, err
+//	    }
+//	    list := loadArticles(c.ID, c.Before, p.Limit)
+//	    var prev, next interface{}
+//	    if len(list) > 0 && c.ID != 0 {
+//	        prev = map[string]interface{}{"ID": list[0].ID, "Before": true}
+//	    }
+//	    if len(list) == p.Limit {
+//	        next = map[string]interface{}{"ID": list[len(list)-1].ID}
+//	    }
+//	    return p.CursorDocument(list, prev, next)
+//	}
+type Paginator struct {
+	// defaults to 20
+	DefaultLimit int
+	// defaults to 100, larger limit is silently lowered
+	MaxLimit int
+	// key to sign cursor tokens, REQUIRED if cursor-style is used. Cursors
+	// are never signed with empty key, E500 is returned instead.
+	Secret []byte
+}
+
+// Page is parsed pagination parameters
+type Page struct {
+	Limit  int
+	Offset int
+
+	cursor string
+	secret []byte
+	uri    *url.URL
+}
+
+func (p Paginator) defaults() (def, max int) {
+	def, max = p.DefaultLimit, p.MaxLimit
+	if max <= 0 {
+		max = 100
+	}
+	if def <= 0 {
+		def = 20
+	}
+	if def > max {
+		def = max
+	}
+	return
+}
+
+func intParam(q url.Values, key string, min int) (ret int, ok bool, err error) {
+	v := q.Get(key)
+	if v == "" {
+		return
+	}
+	ret, err = strconv.Atoi(v)
+	if err == nil && ret < min {
+		err = errors.New(key + " is too small")
+	}
+	return ret, true, err
+}
+
+// Parse parses pagination parameters, returns E400Page if any of them is
+// invalid
+func (p Paginator) Parse(r jsonapi.Request) (ret *Page, err error) {
+	def, max := p.defaults()
+	u := *r.R().URL
+	q := u.Query()
+	ret = &Page{
+		Limit:  def,
+		cursor: q.Get("cursor"),
+		secret: p.Secret,
+		uri:    &u,
+	}
+
+	limit, ok, err := intParam(q, "limit", 1)
+	if err != nil {
+		return nil, E400Page.SetOrigin(err)
+	}
+	if ok {
+		ret.Limit = limit
+	}
+	if ret.Limit > max {
+		ret.Limit = max
+	}
+
+	if ret.Offset, _, err = intParam(q, "offset", 0); err != nil {
+		return nil, E400Page.SetOrigin(err)
+	}
+	page, ok, err := intParam(q, "page", 1)
+	if err != nil {
+		return nil, E400Page.SetOrigin(err)
+	}
+	if ok {
+		if page-1 > math.MaxInt/ret.Limit {
+			return nil, E400Page.SetOrigin(errors.New("page is too large"))
+		}
+		ret.Offset = (page - 1) * ret.Limit
+	}
+	if ret.Offset > math.MaxInt-ret.Limit {
+		// so offset of next page does not overflow
+		return nil, E400Page.SetOrigin(errors.New("offset is too large"))
+	}
+	return
+}
+
+func sign(secret []byte, payload string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// EncodeCursor creates an opaque, tamper-proof cursor token from v. It returns
+// ErrCursorSecret if secret is empty.
+func EncodeCursor(secret []byte, v interface{}) (string, error) {
+	if len(secret) == 0 {
+		return "", ErrCursorSecret
+	}
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(buf)
+	return payload + "." + sign(secret, payload), nil
+}
+
+// DecodeCursor verifies the token created by EncodeCursor and decodes it into v.
+// It returns ErrCursorSecret if secret is empty.
+func DecodeCursor(secret []byte, token string, v interface{}) error {
+	if len(secret) == 0 {
+		return ErrCursorSecret
+	}
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(sign(secret, payload))) {
+		return ErrCursor
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return ErrCursor
+	}
+	if err = json.Unmarshal(buf, v); err != nil {
+		return ErrCursor
+	}
+	return nil
+}
+
+// Cursor decodes cursor parameter into v, returns false if there's no cursor
+//
+// E400Page is returned if the cursor is invalid, or E500 if Secret of the
+// Paginator is not set.
+func (p *Page) Cursor(v interface{}) (ok bool, err error) {
+	if p.cursor == "" {
+		return
+	}
+	if err = DecodeCursor(p.secret, p.cursor, v); err != nil {
+		if err == ErrCursorSecret {
+			return false, jsonapi.E500.SetOrigin(err)
+		}
+		return false, E400Page.SetOrigin(err)
+	}
+	return true, nil
+}
+
+// link creates a relative url to current api with query parameters replaced
+func (p *Page) link(set map[string]string) string {
+	q := p.uri.Query()
+	q.Del("page")
+	for k, v := range set {
+		if v == "" {
+			q.Del(k)
+			continue
+		}
+		q.Set(k, v)
+	}
+	u := url.URL{Path: p.uri.Path, RawQuery: q.Encode()}
+	return u.String()
+}
+
+func length(items interface{}) int {
+	v := reflect.ValueOf(items)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		return v.Len()
+	}
+	return 0
+}
+
+// OffsetDocument creates a jsonapi.Document with links and "total" meta
+//
+// Pass negative total if it is unknown, "next" link is added if items is full
+// in that case.
+func (p *Page) OffsetDocument(items interface{}, total int) jsonapi.Document {
+	limit := strconv.Itoa(p.Limit)
+	ret := jsonapi.Document{
+		Data: items,
+		Links: map[string]string{
+			"first": p.link(map[string]string{"limit": limit, "offset": ""}),
+		},
+	}
+
+	if p.Offset > 0 {
+		prev := p.Offset - p.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		ret.Links["prev"] = p.link(map[string]string{
+			"limit": limit, "offset": strconv.Itoa(prev),
+		})
+	}
+
+	next := p.Offset + p.Limit
+	if (total >= 0 && next < total) || (total < 0 && length(items) >= p.Limit) {
+		ret.Links["next"] = p.link(map[string]string{
+			"limit": limit, "offset": strconv.Itoa(next),
+		})
+	}
+
+	if total >= 0 {
+		ret.Meta = map[string]interface{}{"total": total}
+		if total > 0 {
+			last := (total - 1) / p.Limit * p.Limit
+			ret.Links["last"] = p.link(map[string]string{
+				"limit": limit, "offset": strconv.Itoa(last),
+			})
+		}
+	}
+	return ret
+}
+
+// CursorDocument creates a jsonapi.Document with links. prev and next are
+// encoded into cursor tokens of "prev" and "next" links, which are omitted if
+// nil. E500 is returned if the token cannot be created.
+func (p *Page) CursorDocument(items, prev, next interface{}) (ret jsonapi.Document, err error) {
+	limit := strconv.Itoa(p.Limit)
+	ret = jsonapi.Document{
+		Data: items,
+		Links: map[string]string{
+			"first": p.link(map[string]string{"limit": limit, "cursor": "", "offset": ""}),
+		},
+	}
+
+	for k, v := range map[string]interface{}{"prev": prev, "next": next} {
+		if v == nil {
+			continue
+		}
+		token, e := EncodeCursor(p.secret, v)
+		if e != nil {
+			return ret, jsonapi.E500.SetOrigin(e)
+		}
+		ret.Links[k] = p.link(map[string]string{
+			"limit": limit, "cursor": token, "offset": "",
+		})
+	}
+	return
+}
@@ -0,0 +1,136 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func parsePage(t *testing.T, p Paginator, uri string) (*Page, error) {
+	t.Helper()
+	return p.Parse(jsonapi.FromHTTP(
+		httptest.NewRecorder(),
+		httptest.NewRequest("GET", uri, nil),
+	))
+}
+
+func TestPaginatorParse(t *testing.T) {
+	p := Paginator{DefaultLimit: 10, MaxLimit: 50}
+	cases := []struct {
+		uri    string
+		limit  int
+		offset int
+		ok     bool
+	}{
+		{"/a", 10, 0, true},
+		{"/a?limit=100&offset=5", 50, 5, true},
+		{"/a?limit=20&page=3", 20, 40, true},
+		{"/a?limit=0", 0, 0, false},
+		{"/a?offset=-1", 0, 0, false},
+		{"/a?page=x", 0, 0, false},
+		{"/a?limit=20&page=" + strconv.Itoa(math.MaxInt), 0, 0, false},
+		{"/a?offset=" + strconv.Itoa(math.MaxInt), 0, 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.uri, func(t *testing.T) {
+			pg, err := parsePage(t, p, c.uri)
+			if !c.ok {
+				if e, ok := err.(jsonapi.Error); !ok || !e.EqualTo(E400Page) {
+					t.Fatalf("expected E400Page, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if pg.Limit != c.limit || pg.Offset != c.offset {
+				t.Fatalf("unexpected result: %+v", pg)
+			}
+		})
+	}
+}
+
+func TestOffsetDocument(t *testing.T) {
+	pg, _ := parsePage(t, Paginator{}, "/a?q=x&limit=10&page=2")
+	doc := pg.OffsetDocument([]int{1}, 25)
+
+	expect := map[string]string{
+		"first": "/a?limit=10&q=x",
+		"prev":  "/a?limit=10&offset=0&q=x",
+		"next":  "/a?limit=10&offset=20&q=x",
+		"last":  "/a?limit=10&offset=20&q=x",
+	}
+	if !reflect.DeepEqual(expect, doc.Links) {
+		t.Errorf("expected %v, got %v", expect, doc.Links)
+	}
+	if doc.Meta["total"] != 25 {
+		t.Errorf("unexpected meta: %v", doc.Meta)
+	}
+}
+
+func TestCursor(t *testing.T) {
+	p := Paginator{Secret: []byte("secret")}
+	pg, _ := parsePage(t, p, "/a")
+	doc, err := pg.CursorDocument([]int{1}, nil, 123)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := doc.Links["prev"]; ok {
+		t.Errorf("unexpected prev link: %v", doc.Links)
+	}
+
+	pg, _ = parsePage(t, p, doc.Links["next"])
+	var after int
+	if ok, err := pg.Cursor(&after); !ok || err != nil || after != 123 {
+		t.Fatalf("unexpected result: %v %v %d", ok, err, after)
+	}
+
+	prev, err := pg.CursorDocument([]int{1}, 122, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := prev.Links["next"]; ok {
+		t.Errorf("unexpected next link: %v", prev.Links)
+	}
+	pg, _ = parsePage(t, p, prev.Links["prev"])
+	var before int
+	if ok, err := pg.Cursor(&before); !ok || err != nil || before != 122 {
+		t.Fatalf("unexpected result: %v %v %d", ok, err, before)
+	}
+
+	pg, _ = parsePage(t, Paginator{Secret: []byte("other")}, doc.Links["next"])
+	if _, err = pg.Cursor(&after); err == nil {
+		t.Fatal("expected tampered cursor to be rejected")
+	}
+}
+
+func isE500(err error) bool {
+	e, ok := err.(jsonapi.Error)
+	return ok && e.Code == 500
+}
+
+func TestCursorWithoutSecret(t *testing.T) {
+	pg, _ := parsePage(t, Paginator{}, "/a")
+	if _, err := pg.CursorDocument([]int{1}, nil, 123); !isE500(err) {
+		t.Errorf("expected E500, got %v", err)
+	}
+
+	token, _ := EncodeCursor([]byte("secret"), 123)
+	pg, _ = parsePage(t, Paginator{}, "/a?cursor="+token)
+	var after int
+	if _, err := pg.Cursor(&after); !isE500(err) {
+		t.Errorf("expected E500, got %v", err)
+	}
+	if err := DecodeCursor(nil, token, &after); err != ErrCursorSecret {
+		t.Errorf("expected ErrCursorSecret, got %v", err)
+	}
+}
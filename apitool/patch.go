@@ -0,0 +1,529 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/raohwork/jsonapi"
+)
+
+var (
+	// E400Patch indicates the patch document is malformed
+	E400Patch = jsonapi.E400.SetData("invalid patch document")
+	// E409Patch indicates the patch cannot be applied to the resource
+	E409Patch = jsonapi.E409.SetData("cannot apply patch")
+	// E415Patch indicates the request is not a supported patch format
+	E415Patch = jsonapi.E415.SetData("unsupported patch format")
+)
+
+// patchErr creates an error with JSON pointer in its message
+func patchErr(e jsonapi.Error, msg, ptr string) jsonapi.Error {
+	if ptr == "" {
+		return e.SetData(msg)
+	}
+	return e.SetData(msg + ": " + ptr)
+}
+
+// toJSON encodes v and decodes it as generic value, numbers are kept as
+// json.Number
+func toJSON(v interface{}) (ret interface{}, err error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	err = decodeJSON(buf, &ret)
+	return
+}
+
+func decodeJSON(buf []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// equalJSON compares two generic values, numbers are compared by value
+func equalJSON(a, b interface{}) bool {
+	switch x := a.(type) {
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		if x == y {
+			return true
+		}
+		fx, e1 := x.Float64()
+		fy, e2 := y.Float64()
+		return e1 == nil && e2 == nil && fx == fy
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !equalJSON(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for idx := range x {
+			if !equalJSON(x[idx], y[idx]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+var ptrEscape = strings.NewReplacer("~", "~0", "/", "~1")
+var ptrUnescape = strings.NewReplacer("~1", "/", "~0", "~")
+
+// diffJSON collects JSON pointers of changed members
+func diffJSON(a, b interface{}, ptr string, ret []string) []string {
+	x, ok1 := a.(map[string]interface{})
+	y, ok2 := b.(map[string]interface{})
+	if ok1 && ok2 {
+		for k, v := range x {
+			w, ok := y[k]
+			p := ptr + "/" + ptrEscape.Replace(k)
+			if !ok {
+				ret = append(ret, p)
+				continue
+			}
+			ret = diffJSON(v, w, p, ret)
+		}
+		for k := range y {
+			if _, ok := x[k]; !ok {
+				ret = append(ret, ptr+"/"+ptrEscape.Replace(k))
+			}
+		}
+		return ret
+	}
+
+	s, ok1 := a.([]interface{})
+	t, ok2 := b.([]interface{})
+	if ok1 && ok2 && len(s) == len(t) {
+		for idx := range s {
+			ret = diffJSON(s[idx], t[idx], ptr+"/"+strconv.Itoa(idx), ret)
+		}
+		return ret
+	}
+
+	if !equalJSON(a, b) {
+		ret = append(ret, ptr)
+	}
+	return ret
+}
+
+// resetJSONFields zeroes fields of v which are encoded to JSON, so fields
+// removed by patch are reset while others (unexported or json:"-") are kept
+func resetJSONFields(v reflect.Value) {
+	if v.Kind() != reflect.Struct {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	for _, f := range reflect.VisibleFields(v.Type()) {
+		if f.Anonymous || !f.IsExported() || f.Tag.Get("json") == "-" {
+			continue
+		}
+		fv, err := v.FieldByIndexErr(f.Index)
+		if err != nil {
+			// nil embedded pointer
+			continue
+		}
+		fv.Set(reflect.Zero(f.Type))
+	}
+}
+
+// applyPatched decodes patched document into target, returns changed members
+func applyPatched(target, orig, patched interface{}) ([]string, error) {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, jsonapi.E500.SetData("target of patch must be a non-nil pointer")
+	}
+
+	buf, err := json.Marshal(patched)
+	if err != nil {
+		return nil, jsonapi.E500.SetOrigin(err)
+	}
+	v := reflect.New(rv.Type().Elem())
+	v.Elem().Set(rv.Elem())
+	resetJSONFields(v.Elem())
+	if err = json.Unmarshal(buf, v.Interface()); err != nil {
+		ptr := ""
+		if e, ok := err.(*json.UnmarshalTypeError); ok && e.Field != "" {
+			ptr = "/" + strings.ReplaceAll(ptrEscape.Replace(e.Field), ".", "/")
+		}
+		return nil, patchErr(E400Patch, "invalid value", ptr).SetOrigin(err)
+	}
+
+	// compare with decoded value, so members unknown to target are ignored
+	result, err := toJSON(v.Interface())
+	if err != nil {
+		return nil, jsonapi.E500.SetOrigin(err)
+	}
+	rv.Elem().Set(v.Elem())
+	changed := diffJSON(orig, result, "", nil)
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// mergePatch implements the algorithm described in RFC 7396
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+// ApplyMergePatch applies JSON Merge Patch (RFC 7396) to target, which must be
+// a non-nil pointer. It returns JSON pointers of changed members.
+//
+// target is encoded to JSON, patched and decoded back, so json tags are
+// honoured. Members removed by the patch are reset to zero value. target is
+// not modified if any error occurred.
+func ApplyMergePatch(target interface{}, patch []byte) (changed []string, err error) {
+	var p interface{}
+	if err = decodeJSON(patch, &p); err != nil {
+		return nil, E400Patch.SetOrigin(err)
+	}
+	orig, err := toJSON(target)
+	if err != nil {
+		return nil, jsonapi.E500.SetOrigin(err)
+	}
+	cur, _ := toJSON(target)
+	return applyPatched(target, orig, mergePatch(cur, p))
+}
+
+type patchOp struct {
+	Op    string           `json:"op"`
+	Path  *string          `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+func parsePointer(ptr string) ([]string, bool) {
+	if ptr == "" {
+		return nil, true
+	}
+	if ptr[0] != '/' {
+		return nil, false
+	}
+	ret := strings.Split(ptr[1:], "/")
+	for idx, x := range ret {
+		ret[idx] = ptrUnescape.Replace(x)
+	}
+	return ret, true
+}
+
+// arrayIndex parses index of array, "-" means len(arr) if allowed
+func arrayIndex(tok string, size int, dash bool) (int, bool) {
+	if tok == "-" && dash {
+		return size, true
+	}
+	if tok == "" || (len(tok) > 1 && tok[0] == '0') {
+		return 0, false
+	}
+	idx, err := strconv.Atoi(tok)
+	if err != nil || idx < 0 {
+		return 0, false
+	}
+	return idx, true
+}
+
+type jsonPatcher struct {
+	doc interface{}
+}
+
+// get retrieves value at ptr
+func (p *jsonPatcher) get(tokens []string) (interface{}, bool) {
+	cur := p.doc
+	for _, tok := range tokens {
+		switch x := cur.(type) {
+		case map[string]interface{}:
+			v, ok := x[tok]
+			if !ok {
+				return nil, false
+			}
+			cur = v
+		case []interface{}:
+			idx, ok := arrayIndex(tok, len(x), false)
+			if !ok || idx >= len(x) {
+				return nil, false
+			}
+			cur = x[idx]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// update modifies the container of the last token by f, which returns new
+// container
+func (p *jsonPatcher) update(tokens []string, f func(parent interface{}, tok string) (interface{}, bool)) bool {
+	if len(tokens) == 0 {
+		return false
+	}
+	parentTokens, last := tokens[:len(tokens)-1], tokens[len(tokens)-1]
+	parent, ok := p.get(parentTokens)
+	if !ok {
+		return false
+	}
+	v, ok := f(parent, last)
+	if !ok {
+		return false
+	}
+	if len(parentTokens) == 0 {
+		p.doc = v
+		return true
+	}
+
+	// replace parent in grandparent, needed if parent is a slice
+	return p.update(parentTokens, func(gp interface{}, tok string) (interface{}, bool) {
+		switch x := gp.(type) {
+		case map[string]interface{}:
+			x[tok] = v
+			return x, true
+		case []interface{}:
+			idx, _ := arrayIndex(tok, len(x), false)
+			x[idx] = v
+			return x, true
+		}
+		return nil, false
+	})
+}
+
+func (p *jsonPatcher) add(tokens []string, v interface{}) bool {
+	if len(tokens) == 0 {
+		p.doc = v
+		return true
+	}
+	return p.update(tokens, func(parent interface{}, tok string) (interface{}, bool) {
+		switch x := parent.(type) {
+		case map[string]interface{}:
+			x[tok] = v
+			return x, true
+		case []interface{}:
+			idx, ok := arrayIndex(tok, len(x), true)
+			if !ok || idx > len(x) {
+				return nil, false
+			}
+			x = append(x, nil)
+			copy(x[idx+1:], x[idx:])
+			x[idx] = v
+			return x, true
+		}
+		return nil, false
+	})
+}
+
+func (p *jsonPatcher) remove(tokens []string) (ret interface{}, ok bool) {
+	if ret, ok = p.get(tokens); !ok || len(tokens) == 0 {
+		return nil, false
+	}
+	ok = p.update(tokens, func(parent interface{}, tok string) (interface{}, bool) {
+		switch x := parent.(type) {
+		case map[string]interface{}:
+			delete(x, tok)
+			return x, true
+		case []interface{}:
+			idx, _ := arrayIndex(tok, len(x), false)
+			return append(x[:idx], x[idx+1:]...), true
+		}
+		return nil, false
+	})
+	return
+}
+
+// deepCopy copies generic json value
+func deepCopy(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			ret[k] = deepCopy(v)
+		}
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(x))
+		for idx, v := range x {
+			ret[idx] = deepCopy(v)
+		}
+		return ret
+	}
+	return v
+}
+
+func (p *jsonPatcher) apply(idx int, op patchOp) error {
+	opPtr := "/" + strconv.Itoa(idx)
+	if op.Path == nil {
+		return patchErr(E400Patch, "missing path", opPtr)
+	}
+	path, ok := parsePointer(*op.Path)
+	if !ok {
+		return patchErr(E400Patch, "invalid path", opPtr+"/path")
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return patchErr(E400Patch, "missing value", opPtr)
+		}
+		if err := decodeJSON(*op.Value, &value); err != nil {
+			return patchErr(E400Patch, "invalid value", opPtr+"/value")
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return patchErr(E400Patch, "missing from", opPtr)
+		}
+		from, ok := parsePointer(*op.From)
+		if !ok {
+			return patchErr(E400Patch, "invalid from", opPtr+"/from")
+		}
+		if op.Op == "move" {
+			if *op.Path != *op.From && strings.HasPrefix(*op.Path+"/", *op.From+"/") {
+				return patchErr(E400Patch, "cannot move into itself", *op.Path)
+			}
+			if value, ok = p.remove(from); !ok {
+				return patchErr(E409Patch, "path not found", *op.From)
+			}
+		} else {
+			if value, ok = p.get(from); !ok {
+				return patchErr(E409Patch, "path not found", *op.From)
+			}
+			value = deepCopy(value)
+		}
+	case "remove":
+	default:
+		return patchErr(E400Patch, "unknown operation", opPtr+"/op")
+	}
+
+	switch op.Op {
+	case "add", "move", "copy":
+		ok = p.add(path, value)
+	case "remove":
+		_, ok = p.remove(path)
+	case "replace":
+		if _, ok = p.get(path); ok {
+			if len(path) == 0 {
+				p.doc = value
+			} else {
+				ok = p.update(path, func(parent interface{}, tok string) (interface{}, bool) {
+					switch x := parent.(type) {
+					case map[string]interface{}:
+						x[tok] = value
+						return x, true
+					case []interface{}:
+						idx, _ := arrayIndex(tok, len(x), false)
+						x[idx] = value
+						return x, true
+					}
+					return nil, false
+				})
+			}
+		}
+	case "test":
+		cur, found := p.get(path)
+		if found && !equalJSON(cur, value) {
+			return patchErr(E409Patch, "test failed", *op.Path)
+		}
+		ok = found
+	}
+	if !ok {
+		return patchErr(E409Patch, "path not found", *op.Path)
+	}
+	return nil
+}
+
+// ApplyJSONPatch applies JSON Patch (RFC 6902) to target, which must be a
+// non-nil pointer. It returns JSON pointers of changed members.
+//
+// Malformed patch is rejected with E400Patch, and failed operations (including
+// "test") with E409Patch. Error message contains JSON pointer of the operation
+// or the member. target is not modified if any error occurred.
+func ApplyJSONPatch(target interface{}, patch []byte) (changed []string, err error) {
+	var ops []patchOp
+	if err = json.Unmarshal(patch, &ops); err != nil {
+		return nil, E400Patch.SetOrigin(err)
+	}
+	orig, err := toJSON(target)
+	if err != nil {
+		return nil, jsonapi.E500.SetOrigin(err)
+	}
+
+	cur, _ := toJSON(target)
+	p := &jsonPatcher{doc: cur}
+	for idx, op := range ops {
+		if err = p.apply(idx, op); err != nil {
+			return
+		}
+	}
+	return applyPatched(target, orig, p.doc)
+}
+
+// Patch reads patch document from request body, and applies it to target
+// according to Content-Type:
+//
+//   - application/merge-patch+json and application/json: ApplyMergePatch
+//   - application/json-patch+json: ApplyJSONPatch
+//   - others: E415Patch
+//
+// It returns JSON pointers of changed members, so you can tell omitted
+// members from members set to zero value:
+//
+//	func updateArticle(r jsonapi.Request) (interface{}, error) {
+//	    a := loadArticle(r)
+//	    changed, err := apitool.Patch(r, &a)
+//	    if err != nil {
+//	        return nil, err
+//	    }
+//	    return a, saveArticle(a, changed)
+//	}
+func Patch(r jsonapi.Request, target interface{}) (changed []string, err error) {
+	ct, _, _ := mime.ParseMediaType(r.R().Header.Get("Content-Type"))
+	var f func(interface{}, []byte) ([]string, error)
+	switch ct {
+	case "application/merge-patch+json", "application/json":
+		f = ApplyMergePatch
+	case "application/json-patch+json":
+		f = ApplyJSONPatch
+	default:
+		return nil, E415Patch
+	}
+
+	var buf json.RawMessage
+	if err = r.Decode(&buf); err != nil {
+		return nil, E400Patch.SetOrigin(err)
+	}
+	return f(target, buf)
+}
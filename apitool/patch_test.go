@@ -0,0 +1,208 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raohwork/jsonapi"
+	"github.com/raohwork/jsonapi/apitool/callapi"
+)
+
+type patchTarget struct {
+	Title  string         `json:"title"`
+	Count  int            `json:"count,omitempty"`
+	Tags   []string       `json:"tags"`
+	Extra  map[string]int `json:"extra,omitempty"`
+	hidden int
+}
+
+func patchFixture() patchTarget {
+	return patchTarget{
+		Title:  "a",
+		Count:  3,
+		Tags:   []string{"x", "y"},
+		hidden: 1,
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	cases := []struct {
+		name    string
+		patch   string
+		expect  patchTarget
+		changed []string
+	}{
+		{
+			name:    "set",
+			patch:   `{"title":"b","extra":{"k":1}}`,
+			expect:  patchTarget{Title: "b", Count: 3, Tags: []string{"x", "y"}, Extra: map[string]int{"k": 1}, hidden: 1},
+			changed: []string{"/extra", "/title"},
+		},
+		{
+			name:    "remove",
+			patch:   `{"count":null,"tags":["z"]}`,
+			expect:  patchTarget{Title: "a", Tags: []string{"z"}, hidden: 1},
+			changed: []string{"/count", "/tags"},
+		},
+		{
+			name:    "zero",
+			patch:   `{"title":""}`,
+			expect:  patchTarget{Count: 3, Tags: []string{"x", "y"}, hidden: 1},
+			changed: []string{"/title"},
+		},
+		{
+			name:   "unchanged",
+			patch:  `{"title":"a"}`,
+			expect: patchFixture(),
+		},
+		{
+			// empty map is omitted when encoding, so it is not a change
+			name:   "unknown-member",
+			patch:  `{"nope":1,"extra":{}}`,
+			expect: patchTarget{Title: "a", Count: 3, Tags: []string{"x", "y"}, Extra: map[string]int{}, hidden: 1},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := patchFixture()
+			changed, err := ApplyMergePatch(&v, []byte(c.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(c.expect, v) {
+				t.Errorf("expected %+v, got %+v", c.expect, v)
+			}
+			if !reflect.DeepEqual(c.changed, changed) {
+				t.Errorf("expected changed %v, got %v", c.changed, changed)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	cases := []struct {
+		name    string
+		patch   string
+		expect  patchTarget
+		changed []string
+		code    int
+		msg     string
+	}{
+		{
+			name:    "ops",
+			patch:   `[{"op":"test","path":"/count","value":3.0},{"op":"add","path":"/tags/1","value":"w"},{"op":"remove","path":"/tags/0"},{"op":"copy","from":"/title","path":"/tags/-"},{"op":"replace","path":"/title","value":"b"}]`,
+			expect:  patchTarget{Title: "b", Count: 3, Tags: []string{"w", "y", "a"}, hidden: 1},
+			changed: []string{"/tags", "/title"},
+		},
+		{
+			name:    "move",
+			patch:   `[{"op":"move","from":"/tags/0","path":"/title"}]`,
+			expect:  patchTarget{Title: "x", Count: 3, Tags: []string{"y"}, hidden: 1},
+			changed: []string{"/tags", "/title"},
+		},
+		{
+			name:  "test-failed",
+			patch: `[{"op":"replace","path":"/title","value":"b"},{"op":"test","path":"/count","value":4}]`,
+			code:  409,
+			msg:   "test failed: /count",
+		},
+		{
+			name:  "not-found",
+			patch: `[{"op":"remove","path":"/tags/5"}]`,
+			code:  409,
+			msg:   "path not found: /tags/5",
+		},
+		{
+			name:  "unknown-op",
+			patch: `[{"op":"delete","path":"/title"}]`,
+			code:  400,
+			msg:   "unknown operation: /0/op",
+		},
+		{
+			name:  "missing-value",
+			patch: `[{"op":"add","path":"/title"}]`,
+			code:  400,
+			msg:   "missing value: /0",
+		},
+		{
+			name:  "type",
+			patch: `[{"op":"replace","path":"/count","value":"x"}]`,
+			code:  400,
+			msg:   "invalid value: /count",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			v := patchFixture()
+			changed, err := ApplyJSONPatch(&v, []byte(c.patch))
+			if c.code != 0 {
+				e, ok := err.(jsonapi.Error)
+				if !ok || e.Code != c.code || e.Data() != c.msg {
+					t.Fatalf("expected %d %s, got %v", c.code, c.msg, err)
+				}
+				if !reflect.DeepEqual(patchFixture(), v) {
+					t.Fatalf("target should not be modified: %+v", v)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(c.expect, v) {
+				t.Errorf("expected %+v, got %+v", c.expect, v)
+			}
+			if !reflect.DeepEqual(c.changed, changed) {
+				t.Errorf("expected changed %v, got %v", c.changed, changed)
+			}
+		})
+	}
+}
+
+func TestPatch(t *testing.T) {
+	h := jsonapi.Handler(func(r jsonapi.Request) (interface{}, error) {
+		v := patchFixture()
+		return Patch(r, &v)
+	})
+
+	run := func(ep callapi.Endpoint, param interface{}) string {
+		req, _ := ep(context.TODO(), param)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return strings.TrimSpace(w.Body.String())
+	}
+
+	old := patchFixture()
+	neu := patchFixture()
+	neu.Title, neu.Count = "b", 0
+	merge, err := callapi.MergePatchOf(old, neu)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := `{"data":["/count","/title"]}`
+	if actual := run(callapi.MergePatchEP("PATCH", "/"), merge); actual != expect {
+		t.Errorf("merge patch: expected %s, got %s", expect, actual)
+	}
+
+	p := callapi.JSONPatch{}.Test("/title", "a").Remove("/tags")
+	expect = `{"data":["/tags"]}`
+	if actual := run(callapi.JSONPatchEP("PATCH", "/"), p); actual != expect {
+		t.Errorf("json patch: expected %s, got %s", expect, actual)
+	}
+
+	req := httptest.NewRequest("PATCH", "/", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != 415 {
+		t.Errorf("expected 415, got %d", w.Code)
+	}
+}
@@ -0,0 +1,120 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// Precondition describes current state of the resource, used to evaluate
+// If-Match, If-Unmodified-Since, If-None-Match and If-Modified-Since for
+// optimistic concurrency control and conditional GET
+//
+//	func updateArticle(r jsonapi.Request) (interface{}, error) {
+//	    a, err := loadArticle(r)
+//	    if err != nil {
+//	        return nil, err
+//	    }
+//	    pre := apitool.Precondition{ETag: a.ETag(), Modified: a.UpdatedAt}
+//	    if err = pre.Check(r); err != nil {
+//	        return nil, err
+//	    }
+//	    // update the article
+//	}
+type Precondition struct {
+	// current ETag of the resource, with quotes
+	ETag string
+	// last modified time of the resource, precision is one second
+	Modified time.Time
+	// returns jsonapi.E428 if request has neither If-Match nor
+	// If-Unmodified-Since, see also RequirePrecondition
+	Required bool
+}
+
+// exists reports whether the resource exists, used to match "*"
+func (p Precondition) exists() bool {
+	return p.ETag != "" || !p.Modified.IsZero()
+}
+
+// Check evaluates preconditions in the order defined in RFC 9110 section 13.2.2,
+// except If-Range which is handled by http.ServeContent. It returns jsonapi.E304
+// if If-None-Match or If-Modified-Since fails for GET and HEAD requests, and
+// jsonapi.E412 if other precondition fails.
+//
+// The resource is treated as missing if both ETag and Modified are zero value,
+// so "If-Match: *" fails and "If-None-Match: *" passes.
+func (p Precondition) Check(r jsonapi.Request) error {
+	hdr := r.R().Header
+	im := strings.Join(hdr.Values("If-Match"), ",")
+	ius := hdr.Get("If-Unmodified-Since")
+	if p.Required && im == "" && ius == "" {
+		return jsonapi.E428
+	}
+
+	switch {
+	case im != "":
+		list := parseETags(im)
+		if len(list) == 1 && list[0] == "*" {
+			if !p.exists() {
+				return jsonapi.E412
+			}
+		} else if p.ETag == "" || !matchETag(list, p.ETag, false) {
+			return jsonapi.E412
+		}
+	case ius != "" && !p.Modified.IsZero():
+		t, err := http.ParseTime(ius)
+		if err == nil && p.Modified.Truncate(time.Second).After(t) {
+			return jsonapi.E412
+		}
+	}
+
+	safe := r.R().Method == "GET" || r.R().Method == "HEAD"
+	inm := strings.Join(hdr.Values("If-None-Match"), ",")
+	switch {
+	case inm != "":
+		list := parseETags(inm)
+		matched := p.ETag != "" && matchETag(list, p.ETag, true)
+		if len(list) == 1 && list[0] == "*" {
+			matched = p.exists()
+		}
+		if !matched {
+			break
+		}
+		if safe {
+			return jsonapi.E304
+		}
+		return jsonapi.E412
+	case safe && !p.Modified.IsZero():
+		t, err := http.ParseTime(hdr.Get("If-Modified-Since"))
+		if err == nil && !p.Modified.Truncate(time.Second).After(t) {
+			return jsonapi.E304
+		}
+	}
+
+	return nil
+}
+
+// RequirePrecondition is a middleware rejects unsafe requests (methods other
+// than GET, HEAD and OPTIONS) without If-Match or If-Unmodified-Since header
+// with jsonapi.E428, so handler can assume Precondition.Check always has
+// something to check.
+func RequirePrecondition(h jsonapi.Handler) jsonapi.Handler {
+	return func(r jsonapi.Request) (interface{}, error) {
+		switch r.R().Method {
+		case "GET", "HEAD", "OPTIONS":
+			return h(r)
+		}
+
+		hdr := r.R().Header
+		if hdr.Get("If-Match") == "" && hdr.Get("If-Unmodified-Since") == "" {
+			return nil, jsonapi.E428
+		}
+		return h(r)
+	}
+}
@@ -0,0 +1,124 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestPrecondition(t *testing.T) {
+	mod := time.Now().Add(-time.Hour)
+	before := mod.Add(-time.Minute).UTC().Format(http.TimeFormat)
+	after := mod.Add(time.Minute).UTC().Format(http.TimeFormat)
+	exist := Precondition{ETag: `"v1"`, Modified: mod}
+	missing := Precondition{}
+
+	cases := []struct {
+		name   string
+		pre    Precondition
+		hdr    map[string]string
+		expect *jsonapi.Error
+	}{
+		{"no-header", exist, nil, nil},
+		{"required", Precondition{Required: true}, nil, &jsonapi.E428},
+		{"if-match", exist, map[string]string{"If-Match": `"v0", "v1"`}, nil},
+		{"if-match-fail", exist, map[string]string{"If-Match": `"v0"`}, &jsonapi.E412},
+		{"if-match-weak", exist, map[string]string{"If-Match": `W/"v1"`}, &jsonapi.E412},
+		{"if-match-star", exist, map[string]string{"If-Match": `*`}, nil},
+		{"if-match-star-missing", missing, map[string]string{"If-Match": `*`}, &jsonapi.E412},
+		{"ius", exist, map[string]string{"If-Unmodified-Since": after}, nil},
+		{"ius-fail", exist, map[string]string{"If-Unmodified-Since": before}, &jsonapi.E412},
+		{"if-match-precedes-ius", exist, map[string]string{
+			"If-Match":            `"v1"`,
+			"If-Unmodified-Since": before,
+		}, nil},
+		{"inm-star", exist, map[string]string{"If-None-Match": `*`}, &jsonapi.E412},
+		{"inm-star-missing", missing, map[string]string{"If-None-Match": `*`}, nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/", nil)
+			for k, v := range c.hdr {
+				req.Header.Set(k, v)
+			}
+			err := c.pre.Check(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+			if c.expect == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if e, ok := err.(jsonapi.Error); !ok || !e.EqualTo(*c.expect) {
+				t.Fatalf("expected %v, got %v", c.expect, err)
+			}
+		})
+	}
+}
+
+func TestPreconditionSafe(t *testing.T) {
+	mod := time.Now().Add(-time.Hour)
+	before := mod.Add(-time.Minute).UTC().Format(http.TimeFormat)
+	after := mod.Add(time.Minute).UTC().Format(http.TimeFormat)
+	pre := Precondition{ETag: `"v1"`, Modified: mod}
+
+	cases := []struct {
+		name   string
+		hdr    map[string]string
+		expect *jsonapi.Error
+	}{
+		{"inm", map[string]string{"If-None-Match": `W/"v1"`}, &jsonapi.E304},
+		{"inm-star", map[string]string{"If-None-Match": `*`}, &jsonapi.E304},
+		{"inm-changed", map[string]string{"If-None-Match": `"v0"`}, nil},
+		{"ims", map[string]string{"If-Modified-Since": after}, &jsonapi.E304},
+		{"ims-modified", map[string]string{"If-Modified-Since": before}, nil},
+		{"inm-precedes-ims", map[string]string{
+			"If-None-Match":     `"v0"`,
+			"If-Modified-Since": after,
+		}, nil},
+		{"if-match-fail", map[string]string{"If-Match": `"v0"`}, &jsonapi.E412},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			for k, v := range c.hdr {
+				req.Header.Set(k, v)
+			}
+			err := pre.Check(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+			if c.expect == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if e, ok := err.(jsonapi.Error); !ok || !e.EqualTo(*c.expect) {
+				t.Fatalf("expected %v, got %v", c.expect, err)
+			}
+		})
+	}
+}
+
+func TestRequirePrecondition(t *testing.T) {
+	h := RequirePrecondition(func(r jsonapi.Request) (interface{}, error) {
+		return nil, nil
+	})
+
+	req := httptest.NewRequest("PATCH", "/", nil)
+	_, err := h(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+	if e, ok := err.(jsonapi.Error); !ok || !e.EqualTo(jsonapi.E428) {
+		t.Fatalf("expected E428, got %v", err)
+	}
+
+	req.Header.Set("If-Match", `"x"`)
+	if _, err = h(jsonapi.FromHTTP(httptest.NewRecorder(), req)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
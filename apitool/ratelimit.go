@@ -0,0 +1,326 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// RateLimit defines a token bucket: Burst tokens at most, and Rate tokens are
+// refilled every Period
+type RateLimit struct {
+	Rate   int
+	Period time.Duration
+	// size of the bucket, defaults to Rate
+	Burst int
+}
+
+func (l RateLimit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// ErrRateLimit indicates the RateLimit has non-positive Rate or Period
+var ErrRateLimit = errors.New("invalid rate limit")
+
+func (l RateLimit) valid() bool {
+	return l.Rate > 0 && l.Period > 0
+}
+
+// policy formats l as value of RateLimit-Policy header
+func (l RateLimit) policy() string {
+	ret := strconv.Itoa(l.Rate) + ";w=" + ceilSeconds(l.Period)
+	if l.Burst > 0 {
+		ret += ";burst=" + strconv.Itoa(l.Burst)
+	}
+	return ret
+}
+
+// PerSecond creates a RateLimit allows n requests per second
+func PerSecond(n int) RateLimit { return RateLimit{Rate: n, Period: time.Second} }
+
+// PerMinute creates a RateLimit allows n requests per minute
+func PerMinute(n int) RateLimit { return RateLimit{Rate: n, Period: time.Minute} }
+
+// RateLimitResult is the result of consuming a token
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// time until the bucket is full again
+	Reset time.Duration
+	// time until next token is available, only meaningful if not allowed
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps states of token buckets
+//
+// Implement it to share states across processes, with redis for example.
+type RateLimitStore interface {
+	// Take consumes a token from the bucket identified by key
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// time to refill an empty bucket
+	full time.Duration
+}
+
+// take updates the bucket and consumes a token if possible
+func (b *bucket) take(l RateLimit, now time.Time) (ret RateLimitResult) {
+	burst := float64(l.burst())
+	perToken := float64(l.Period) / float64(l.Rate)
+
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if d := now.Sub(b.last); d > 0 {
+		b.tokens = math.Min(burst, b.tokens+float64(d)/perToken)
+	}
+	b.last = now
+	b.full = time.Duration(burst * perToken)
+
+	ret.Limit = l.burst()
+	if b.tokens >= 1 {
+		b.tokens--
+		ret.Allowed = true
+	} else {
+		ret.RetryAfter = time.Duration((1 - b.tokens) * perToken)
+	}
+	ret.Remaining = int(b.tokens)
+	ret.Reset = time.Duration((burst - b.tokens) * perToken)
+	return
+}
+
+type rateShard struct {
+	lock    sync.Mutex
+	buckets map[string]*bucket
+	ops     int
+}
+
+// MemoryRateLimitStore is an in-memory RateLimitStore, which splits buckets into
+// shards to reduce lock contention. Zero value is ready to use.
+//
+// Idle buckets are removed periodically while taking tokens.
+type MemoryRateLimitStore struct {
+	// number of shards, defaults to 32. Changing it after first use is not
+	// supported.
+	Shards int
+
+	once   sync.Once
+	shards []*rateShard
+}
+
+func (s *MemoryRateLimitStore) shard(key string) *rateShard {
+	s.once.Do(func() {
+		n := s.Shards
+		if n <= 0 {
+			n = 32
+		}
+		s.shards = make([]*rateShard, n)
+		for x := range s.shards {
+			s.shards[x] = &rateShard{buckets: map[string]*bucket{}}
+		}
+	})
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// Take implements RateLimitStore
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	now := time.Now()
+	sh := s.shard(key)
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+
+	b, ok := sh.buckets[key]
+	if !ok {
+		b = &bucket{}
+		sh.buckets[key] = b
+	}
+	ret := b.take(limit, now)
+
+	sh.ops++
+	if sh.ops >= 1024 {
+		sh.ops = 0
+		sh.sweep(now)
+	}
+	return ret, nil
+}
+
+// sweep removes buckets which are full again, which is identical to a new bucket
+func (sh *rateShard) sweep(now time.Time) {
+	for k, b := range sh.buckets {
+		if now.Sub(b.last) > b.full {
+			delete(sh.buckets, k)
+		}
+	}
+}
+
+// RateLimitByIP identifies client by remote address
+//
+// If your server is behind a reverse proxy, you should write your own function
+// to extract client ip from X-Forwarded-For or other headers.
+func RateLimitByIP(r jsonapi.Request) string {
+	host, _, err := net.SplitHostPort(r.R().RemoteAddr)
+	if err != nil {
+		return r.R().RemoteAddr
+	}
+	return host
+}
+
+// RateLimitByHeader identifies client by value of request header, an api key
+// for example. It falls back to RateLimitByIP if the header is missing, so such
+// requests do not share one bucket.
+func RateLimitByHeader(key string) func(jsonapi.Request) string {
+	return func(r jsonapi.Request) string {
+		if v := r.R().Header.Get(key); v != "" {
+			return "header:" + v
+		}
+		return RateLimitByIP(r)
+	}
+}
+
+// RateLimitByPrincipal identifies client by the authenticated principal, which
+// is identified by f, and falls back to RateLimitByIP if f returns empty string.
+// Use it after authentication middleware, so each user has own bucket no
+// matter where they come from.
+//
+//	// with JWTAuth[MyClaims]
+//	Key: apitool.RateLimitByPrincipal(func(r jsonapi.Request) string {
+//	    c, _ := apitool.JWTClaimsFrom[MyClaims](r.R().Context())
+//	    return c.Subject
+//	}),
+//
+//	// with SessionManager[MySession]
+//	Key: apitool.RateLimitByPrincipal(func(r jsonapi.Request) string {
+//	    return apitool.SessionOf[MySession](r).Data.UserID
+//	}),
+func RateLimitByPrincipal(f func(jsonapi.Request) string) func(jsonapi.Request) string {
+	return func(r jsonapi.Request) string {
+		if id := f(r); id != "" {
+			return "principal:" + id
+		}
+		return RateLimitByIP(r)
+	}
+}
+
+// RateLimitByRoute shares one bucket among all clients calling same API, see
+// jsonapi.PatternOf
+func RateLimitByRoute(r jsonapi.Request) string {
+	return jsonapi.PatternOf(r.R())
+}
+
+// RateLimitByRouteAnd limits every client on every API, where client is
+// identified by f
+func RateLimitByRouteAnd(f func(jsonapi.Request) string) func(jsonapi.Request) string {
+	return func(r jsonapi.Request) string {
+		return jsonapi.PatternOf(r.R()) + "\x00" + f(r)
+	}
+}
+
+// E429RateLimit is a predefined error indicates client has sent too many requests
+var E429RateLimit = jsonapi.E429.SetData("rate limit exceeded")
+
+// RateLimiter is a middleware to limit request rate with token bucket algorithm
+//
+// It sets RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and
+// RateLimit-Policy headers described in IETF draft "RateLimit header fields for
+// HTTP", and Retry-After if the request is rejected with E429RateLimit.
+//
+// Each call to Middleware creates a new store if Store is nil, so every API in
+// a Registerer has its own buckets. Set Store explicitly to share a limit among
+// APIs in the Registerer.
+//
+//	// 10 requests per second per client for each api in group
+//	jsonapi.With(apitool.RateLimiter{
+//	    Limit: apitool.PerSecond(10),
+//	}.Middleware).Register(mux, apis)
+//
+//	// 100 requests per minute per client for all apis in group
+//	jsonapi.With(apitool.RateLimiter{
+//	    Limit: apitool.PerMinute(100),
+//	    Store: &apitool.MemoryRateLimitStore{},
+//	}.Middleware).Register(mux, apis)
+//
+// If Store returns an error, the request is allowed. If the limit has
+// non-positive Rate or Period, the request is rejected with E500.
+type RateLimiter struct {
+	// REQUIRED
+	Limit RateLimit
+	// overrides Limit for the request if returns true, APIKeyRateLimit for
+	// example
+	LimitOf func(jsonapi.Request) (RateLimit, bool)
+	// identifies the client, defaults to RateLimitByIP
+	Key func(jsonapi.Request) string
+	// defaults to a new MemoryRateLimitStore for each Middleware call
+	Store RateLimitStore
+	// prepended to the key, so RateLimiters can share same store
+	Name string
+	// defaults to E429RateLimit
+	Failed func(r jsonapi.Request, res RateLimitResult) error
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// Middleware is the *real* middleware part of RateLimiter
+func (l RateLimiter) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	// safe to set struct member as it is passed by value
+	if l.Key == nil {
+		l.Key = RateLimitByIP
+	}
+	if l.Store == nil {
+		l.Store = &MemoryRateLimitStore{}
+	}
+	if l.Failed == nil {
+		l.Failed = func(jsonapi.Request, RateLimitResult) error {
+			return E429RateLimit
+		}
+	}
+
+	return func(r jsonapi.Request) (data interface{}, err error) {
+		limit := l.Limit
+		if l.LimitOf != nil {
+			if x, ok := l.LimitOf(r); ok {
+				limit = x
+			}
+		}
+		if !limit.valid() {
+			return nil, jsonapi.E500.SetOrigin(ErrRateLimit)
+		}
+		res, e := l.Store.Take(r.R().Context(), l.Name+"\x00"+l.Key(r), limit)
+		if e != nil {
+			return h(r)
+		}
+
+		hdr := r.W().Header()
+		hdr.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		hdr.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		hdr.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+		hdr.Set("RateLimit-Policy", limit.policy())
+		if !res.Allowed {
+			hdr.Set("Retry-After", ceilSeconds(res.RetryAfter))
+			return nil, l.Failed(r, res)
+		}
+
+		return h(r)
+	}
+}
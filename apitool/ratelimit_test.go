@@ -0,0 +1,123 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestBucket(t *testing.T) {
+	l := RateLimit{Rate: 1, Period: time.Second, Burst: 2}
+	b := &bucket{}
+	now := time.Now()
+
+	steps := []struct {
+		after   time.Duration
+		allowed bool
+		remain  int
+	}{
+		{0, true, 1},
+		{0, true, 0},
+		{0, false, 0},
+		{500 * time.Millisecond, false, 0},
+		{500 * time.Millisecond, true, 0},
+		{5 * time.Second, true, 1},
+	}
+
+	for idx, s := range steps {
+		now = now.Add(s.after)
+		res := b.take(l, now)
+		if res.Allowed != s.allowed || res.Remaining != s.remain {
+			t.Fatalf("#%d: unexpected result %+v", idx, res)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	h := RateLimiter{
+		Limit: RateLimit{Rate: 1, Period: time.Minute},
+	}.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		return 1, nil
+	})
+
+	run := func(addr string) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		_, err := h(jsonapi.FromHTTP(w, req))
+		return w, err
+	}
+
+	w, err := run("1.2.3.4:5678")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if x := w.Header().Get("RateLimit-Remaining"); x != "0" {
+		t.Errorf("unexpected remaining: %s", x)
+	}
+	if x := w.Header().Get("RateLimit-Policy"); x != "1;w=60" {
+		t.Errorf("unexpected policy: %s", x)
+	}
+
+	w, err = run("1.2.3.4:9876")
+	if e, ok := err.(jsonapi.Error); !ok || !e.EqualTo(E429RateLimit) {
+		t.Fatalf("expected E429RateLimit, got %v", err)
+	}
+	if x := w.Header().Get("Retry-After"); x != "60" {
+		t.Errorf("unexpected Retry-After: %s", x)
+	}
+
+	if _, err = run("4.3.2.1:5678"); err != nil {
+		t.Fatalf("other client should not be limited: %v", err)
+	}
+}
+
+func TestRateLimiterInvalid(t *testing.T) {
+	h := RateLimiter{
+		Limit: RateLimit{Period: time.Minute},
+	}.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		return 1, nil
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	_, err := h(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+	if e, ok := err.(jsonapi.Error); !ok || e.Code != 500 {
+		t.Fatalf("expected E500, got %v", err)
+	}
+}
+
+func TestRateLimitByPrincipal(t *testing.T) {
+	key := RateLimitByPrincipal(func(r jsonapi.Request) string {
+		return r.R().Header.Get("X-User")
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	if x := key(jsonapi.FromHTTP(httptest.NewRecorder(), req)); x != "1.2.3.4" {
+		t.Errorf("expected to fall back to ip, got %s", x)
+	}
+	req.Header.Set("X-User", "john")
+	if x := key(jsonapi.FromHTTP(httptest.NewRecorder(), req)); x != "principal:john" {
+		t.Errorf("unexpected key: %s", x)
+	}
+}
+
+func TestRateLimitByHeader(t *testing.T) {
+	key := RateLimitByHeader("X-API-Key")
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	if x := key(jsonapi.FromHTTP(httptest.NewRecorder(), req)); x != "1.2.3.4" {
+		t.Errorf("expected to fall back to ip, got %s", x)
+	}
+	req.Header.Set("X-API-Key", "k1")
+	if x := key(jsonapi.FromHTTP(httptest.NewRecorder(), req)); x != "header:k1" {
+		t.Errorf("unexpected key: %s", x)
+	}
+}
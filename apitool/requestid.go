@@ -0,0 +1,150 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net/http"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// crockford base32 alphabet, which is lexically sortable
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewRequestID generates a 26 characters unique id which is sortable by time
+//
+// The format is identical to ULID: 48 bits timestamp in milliseconds followed by
+// 80 bits random value, encoded in Crockford's base32.
+func NewRequestID() string {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], uint64(time.Now().UnixMilli())<<16)
+	rand.Read(buf[6:])
+
+	// 128 bits, encoded 5 bits a time from the most significant bit; the
+	// first character takes only 3 bits
+	hi := binary.BigEndian.Uint64(buf[:8])
+	lo := binary.BigEndian.Uint64(buf[8:])
+	ret := make([]byte, 26)
+	for x := 25; x >= 0; x-- {
+		ret[x] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(ret)
+}
+
+type requestIDKey struct{}
+
+type requestID struct {
+	id     string
+	header string
+}
+
+// RequestIDFrom retrieves request id stored by RequestID middleware
+func RequestIDFrom(ctx context.Context) string {
+	ret, _ := ctx.Value(requestIDKey{}).(requestID)
+	return ret.id
+}
+
+// ContextWithRequestID creates a new context carrying the request id, which
+// will be sent in X-Request-ID header by ForwardRequestID
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID{
+		id:     id,
+		header: "X-Request-ID",
+	})
+}
+
+// ForwardRequestID adds request id stored in req.Context() to request header,
+// so you can trace an user action across services. It uses the header name
+// configured in RequestID middleware, and is designed to be used with
+// callapi.Endpoint.With:
+//
+//	ep := callapi.NewEP("POST", uri).With(apitool.ForwardRequestID)
+//	err := ep.DefaultCaller().Call(r.R().Context(), param, &result)
+//
+// It does nothing if there's no request id.
+func ForwardRequestID(req *http.Request) (*http.Request, error) {
+	v, ok := req.Context().Value(requestIDKey{}).(requestID)
+	if ok && v.id != "" {
+		req.Header.Set(v.header, v.id)
+	}
+	return req, nil
+}
+
+// validRequestID prevents malicious client to pollute the log
+func validRequestID(id string) bool {
+	if l := len(id); l == 0 || l > 128 {
+		return false
+	}
+	for _, c := range []byte(id) {
+		if c <= 0x20 || c >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestID is a middleware to identify each request
+//
+// It uses the request id passed in request header, or generates a new one if it
+// is missing or invalid. The id is
+//
+//   - stored in request context, see RequestIDFrom and ForwardRequestID.
+//   - sent back in response header.
+//   - added to error object as "id" member, see jsonapi.Error.SetID.
+//
+// To add the id to error object, errors other than jsonapi.Error are converted
+// to jsonapi.E500.SetOrigin(err).SetData(err.Error()), which generates same
+// response except the id. Original error can still be found by errors.Is and
+// errors.As, see jsonapi.Error.Unwrap.
+type RequestID struct {
+	// header to read and write the id, defaults to "X-Request-ID"
+	Header string
+	// defaults to NewRequestID
+	Generate func() string
+	// always generates new id, ignores the one passed in request header
+	Untrusted bool
+}
+
+// Middleware is the *real* middleware part of RequestID
+func (m RequestID) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	// safe to set struct member as it is passed by value
+	if m.Header == "" {
+		m.Header = "X-Request-ID"
+	}
+	if m.Generate == nil {
+		m.Generate = NewRequestID
+	}
+
+	return func(r jsonapi.Request) (data interface{}, err error) {
+		id := r.R().Header.Get(m.Header)
+		if m.Untrusted || !validRequestID(id) {
+			id = m.Generate()
+		}
+
+		r.W().Header().Set(m.Header, id)
+		data, err = h(r.WithValue(requestIDKey{}, requestID{
+			id:     id,
+			header: m.Header,
+		}))
+		if err == nil {
+			return
+		}
+
+		e, ok := err.(jsonapi.Error)
+		if !ok {
+			e = jsonapi.E500.SetOrigin(err).SetData(err.Error())
+		}
+		if e.EqualTo(jsonapi.ASIS) {
+			return
+		}
+		return data, e.SetID(id)
+	}
+}
@@ -0,0 +1,91 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestNewRequestIDSortable(t *testing.T) {
+	a := NewRequestID()
+	time.Sleep(2 * time.Millisecond)
+	b := NewRequestID()
+
+	if len(a) != 26 || len(b) != 26 {
+		t.Fatalf("unexpected length: %s, %s", a, b)
+	}
+	if a >= b {
+		t.Fatalf("expected %s < %s", a, b)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var out *http.Request
+	h := jsonapi.Handler(RequestID{}.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		req, _ := http.NewRequestWithContext(r.R().Context(), "GET", "http://a.b", nil)
+		out, _ = ForwardRequestID(req)
+		return nil, errors.New("my error")
+	}))
+
+	cases := []struct {
+		name   string
+		header string
+		expect string
+	}{
+		{name: "passed", header: "my-id", expect: "my-id"},
+		{name: "missing"},
+		{name: "invalid", header: "my id"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if c.header != "" {
+				req.Header.Set("X-Request-ID", c.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			id := w.Header().Get("X-Request-ID")
+			if c.expect != "" && id != c.expect {
+				t.Fatalf("expected %s, got %s", c.expect, id)
+			}
+			if len(id) == 0 || id == c.header && c.expect == "" {
+				t.Fatalf("unexpected id: %s", id)
+			}
+			if x := out.Header.Get("X-Request-ID"); x != id {
+				t.Errorf("expected forwarded id %s, got %s", id, x)
+			}
+
+			expect := `{"errors":[{"id":"` + id + `","detail":"my error"}]}`
+			if x := strings.TrimSpace(w.Body.String()); x != expect {
+				t.Errorf("expected %s, got %s", expect, x)
+			}
+			if w.Code != 500 {
+				t.Errorf("unexpected status: %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestRequestIDKeepsOrigin(t *testing.T) {
+	myErr := errors.New("my error")
+	h := RequestID{}.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		return nil, myErr
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	_, err := h(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+	if !errors.Is(err, myErr) {
+		t.Fatalf("expected original error to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// statusOf computes the http status code and application-defined error code
+// that jsonapi.Handler would send to client
+func statusOf(data interface{}, err error) (status int, code string) {
+	if err == nil {
+		status, _, _ = jsonapi.UnwrapResult(data)
+		return status, ""
+	}
+
+	e, ok := err.(jsonapi.Error)
+	if !ok {
+		return http.StatusInternalServerError, ""
+	}
+	if e.EqualTo(jsonapi.ASIS) {
+		return http.StatusOK, ""
+	}
+
+	return e.Code, e.ErrCode()
+}
+
+// errFileResult indicates the result is a jsonapi.File, which cannot be saved
+var errFileResult = errors.New("cannot encode file response")
+
+// encodeResult encodes data returned by handler, and reports whether it is a
+// jsonapi.Document. Resources are converted by jsonapi.ResourceDocument first,
+// and errFileResult is returned for jsonapi.File.
+func encodeResult(r *http.Request, data interface{}) (buf json.RawMessage, doc bool, err error) {
+	if jsonapi.IsFile(data) {
+		return nil, false, errFileResult
+	}
+	if data, err = jsonapi.ResourceDocument(r, data); err != nil {
+		return
+	}
+	switch data.(type) {
+	case jsonapi.Document, *jsonapi.Document:
+		doc = true
+	}
+	buf, err = json.Marshal(data)
+	return
+}
+
+// rawDocument is used to decode an encoded jsonapi.Document without touching
+// its data
+type rawDocument struct {
+	Data     json.RawMessage           `json:"data"`
+	Links    map[string]string         `json:"links,omitempty"`
+	Meta     map[string]interface{}    `json:"meta,omitempty"`
+	Included []*jsonapi.ResourceObject `json:"included,omitempty"`
+}
+
+// decodeResult reverts encodeResult, the data is kept as json.RawMessage
+func decodeResult(buf json.RawMessage, doc bool) interface{} {
+	if !doc {
+		return buf
+	}
+
+	var x rawDocument
+	if err := json.Unmarshal(buf, &x); err != nil {
+		return buf
+	}
+	return jsonapi.Document{
+		Data:     x.Data,
+		Links:    x.Links,
+		Meta:     x.Meta,
+		Included: x.Included,
+	}
+}
@@ -0,0 +1,428 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// SessionStore saves server-side sessions
+//
+// Implement it to share sessions across processes, with redis for example.
+type SessionStore interface {
+	// Load returns nil if not found or expired
+	Load(ctx context.Context, id string) ([]byte, error)
+	Save(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	Delete(ctx context.Context, id string) error
+}
+
+type memSession struct {
+	data   []byte
+	expire time.Time
+}
+
+// MemorySessionStore is an in-memory SessionStore. Zero value is ready to use.
+type MemorySessionStore struct {
+	lock    sync.Mutex
+	entries map[string]memSession
+	ops     int
+}
+
+// Load implements SessionStore
+func (s *MemorySessionStore) Load(_ context.Context, id string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.entries[id]
+	if !ok || time.Now().After(e.expire) {
+		return nil, nil
+	}
+	return e.data, nil
+}
+
+// Save implements SessionStore
+func (s *MemorySessionStore) Save(_ context.Context, id string, data []byte, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if s.entries == nil {
+		s.entries = map[string]memSession{}
+	}
+	if s.ops++; s.ops >= 1024 {
+		s.ops = 0
+		for k, e := range s.entries {
+			if now.After(e.expire) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	s.entries[id] = memSession{data: data, expire: now.Add(ttl)}
+	return nil
+}
+
+// Delete implements SessionStore
+func (s *MemorySessionStore) Delete(_ context.Context, id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.entries, id)
+	return nil
+}
+
+// Session is the session of a client, see SessionManager
+type Session[T any] struct {
+	Data T
+
+	id          string
+	oldID       string
+	created     time.Time
+	seen        time.Time
+	isNew       bool
+	destroyed   bool
+	regenerated bool
+}
+
+// ID returns the session id, which is random and safe to be used as key of
+// other data, or bound to CSRF token
+func (s *Session[T]) ID() string { return s.id }
+
+// CreatedAt returns when the session is created
+func (s *Session[T]) CreatedAt() time.Time { return s.created }
+
+// IsNew reports whether the session is created by this request
+func (s *Session[T]) IsNew() bool { return s.isNew }
+
+// Regenerate changes session id and restarts absolute expiry, data is kept.
+// Call it after login to prevent session fixation.
+func (s *Session[T]) Regenerate() {
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newSessionID()
+	s.created = time.Now()
+	s.regenerated = true
+}
+
+// Destroy clears the data and removes the session, call it when logging out
+func (s *Session[T]) Destroy() {
+	var zero T
+	s.Data = zero
+	s.destroyed = true
+}
+
+func newSessionID() string {
+	buf := make([]byte, 18)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+type sessionKey struct{}
+
+// SessionOf retrieves the session created by SessionManager[T], it returns nil
+// if the middleware is not used, or T is different
+func SessionOf[T any](r jsonapi.Request) *Session[T] {
+	ret, _ := r.R().Context().Value(sessionKey{}).(*Session[T])
+	return ret
+}
+
+// E500Session indicates failed to save the session
+var E500Session = jsonapi.E500.SetData("failed to save session")
+
+// ErrSessionKey indicates SessionManager.Keys is empty or contains an empty key
+var ErrSessionKey = errors.New("session keys must not be empty")
+
+// SessionManager is a middleware provides sessions in cookie
+//
+// Without Store, whole session is saved in the cookie, signed with
+// HMAC-SHA256, or encrypted with AES-GCM if Encrypt is true. With Store, the
+// cookie only carries signed session id.
+//
+//	type MySession struct {
+//	    UserID int `json:"uid"`
+//	}
+//
+//	jsonapi.With(apitool.SessionManager[MySession]{
+//	    Keys:   [][]byte{newKey, oldKey},
+//	    Secure: true,
+//	}.Middleware).Register(mux, apis)
+//
+//	func login(q jsonapi.Request) (interface{}, error) {
+//	    sess := apitool.SessionOf[MySession](q)
+//	    sess.Regenerate()
+//	    sess.Data.UserID = uid
+//	    ...
+//	}
+//
+// Session data is encoded with encoding/json, and the session is saved only
+// if the data is changed, or every minute to extend idle expiry.
+type SessionManager[T any] struct {
+	// REQUIRED, first key is used to sign new cookies, all keys are used to
+	// verify. Add new key at the front to rotate keys, cookies signed by old
+	// keys are re-signed with new key. Requests are rejected with E500Session
+	// if it is empty or contains an empty key.
+	Keys [][]byte
+	// encrypts the cookie with AES-GCM, ignored if Store is set
+	Encrypt bool
+	// saves sessions at server-side if set
+	Store SessionStore
+	// defaults to "session"
+	CookieName string
+	// defaults to "/"
+	Path   string
+	Domain string
+	Secure bool
+	// defaults to http.SameSiteLaxMode
+	SameSite http.SameSite
+	// session expires if not used for IdleTimeout, defaults to 30 minutes
+	IdleTimeout time.Duration
+	// session expires after MaxAge since created, defaults to 24 hours
+	MaxAge time.Duration
+}
+
+type sessionEnvelope struct {
+	ID      string          `json:"i"`
+	Created int64           `json:"c"`
+	Seen    int64           `json:"s"`
+	Data    json.RawMessage `json:"d,omitempty"`
+}
+
+// deriveKey derives sub key for different usage, so same key can be used to
+// sign and encrypt
+func deriveKey(key []byte, usage string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(usage))
+	return mac.Sum(nil)
+}
+
+func (m SessionManager[T]) seal(key, payload []byte) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	if !m.Encrypt || m.Store != nil {
+		mac := hmac.New(sha256.New, deriveKey(key, "session-sign"))
+		mac.Write([]byte(m.CookieName + "|"))
+		mac.Write(payload)
+		return enc(payload) + "." + enc(mac.Sum(nil))
+	}
+
+	block, _ := aes.NewCipher(deriveKey(key, "session-encrypt"))
+	gcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, gcm.NonceSize())
+	rand.Read(nonce)
+	return enc(gcm.Seal(nonce, nonce, payload, []byte(m.CookieName)))
+}
+
+func (m SessionManager[T]) open(key []byte, value string) ([]byte, bool) {
+	dec := base64.RawURLEncoding.DecodeString
+	if !m.Encrypt || m.Store != nil {
+		p, s, ok := strings.Cut(value, ".")
+		if !ok {
+			return nil, false
+		}
+		payload, err := dec(p)
+		if err != nil {
+			return nil, false
+		}
+		sig, err := dec(s)
+		if err != nil {
+			return nil, false
+		}
+		mac := hmac.New(sha256.New, deriveKey(key, "session-sign"))
+		mac.Write([]byte(m.CookieName + "|"))
+		mac.Write(payload)
+		return payload, hmac.Equal(sig, mac.Sum(nil))
+	}
+
+	buf, err := dec(value)
+	if err != nil {
+		return nil, false
+	}
+	block, _ := aes.NewCipher(deriveKey(key, "session-encrypt"))
+	gcm, _ := cipher.NewGCM(block)
+	if len(buf) < gcm.NonceSize() {
+		return nil, false
+	}
+	payload, err := gcm.Open(nil, buf[:gcm.NonceSize()], buf[gcm.NonceSize():], []byte(m.CookieName))
+	return payload, err == nil
+}
+
+// unseal tries all keys, rotate is true if it's not sealed by first key
+func (m SessionManager[T]) unseal(value string) (payload []byte, rotate, ok bool) {
+	for idx, key := range m.Keys {
+		if payload, ok = m.open(key, value); ok {
+			return payload, idx > 0, true
+		}
+	}
+	return nil, false, false
+}
+
+func (m SessionManager[T]) expired(env sessionEnvelope, now time.Time) bool {
+	return now.Sub(time.Unix(env.Seen, 0)) > m.IdleTimeout ||
+		now.Sub(time.Unix(env.Created, 0)) > m.MaxAge
+}
+
+// load loads the session, returns the envelope as loaded, or nil if it's new
+func (m SessionManager[T]) load(req *http.Request, now time.Time) (sess *Session[T], loaded *sessionEnvelope, rotate bool) {
+	sess = &Session[T]{id: newSessionID(), created: now, seen: now, isNew: true}
+	c, err := req.Cookie(m.CookieName)
+	if err != nil {
+		return
+	}
+	payload, rotate, ok := m.unseal(c.Value)
+	if !ok {
+		return sess, nil, false
+	}
+	if m.Store != nil {
+		id := string(payload)
+		if payload, err = m.Store.Load(req.Context(), id); err != nil || payload == nil {
+			return sess, nil, false
+		}
+	}
+
+	var env sessionEnvelope
+	if json.Unmarshal(payload, &env) != nil || m.expired(env, now) {
+		return sess, nil, false
+	}
+	var data T
+	if len(env.Data) > 0 && json.Unmarshal(env.Data, &data) != nil {
+		return sess, nil, false
+	}
+	sess = &Session[T]{
+		Data:    data,
+		id:      env.ID,
+		created: time.Unix(env.Created, 0),
+		seen:    time.Unix(env.Seen, 0),
+	}
+	return sess, &env, rotate
+}
+
+func (m SessionManager[T]) cookie(value string, maxAge time.Duration) *http.Cookie {
+	ret := &http.Cookie{
+		Name:     m.CookieName,
+		Value:    value,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+		MaxAge:   int(maxAge / time.Second),
+	}
+	if maxAge <= 0 {
+		ret.MaxAge = -1
+	}
+	return ret
+}
+
+func (m SessionManager[T]) save(r jsonapi.Request, sess *Session[T], loaded *sessionEnvelope, rotate bool, now time.Time) error {
+	ctx := r.R().Context()
+	if sess.oldID != "" && m.Store != nil {
+		m.Store.Delete(ctx, sess.oldID)
+	}
+	if sess.destroyed {
+		if m.Store != nil && !sess.isNew {
+			m.Store.Delete(ctx, sess.id)
+		}
+		if !sess.isNew {
+			http.SetCookie(r.W(), m.cookie("", 0))
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(sess.Data)
+	if err != nil {
+		return E500Session.SetOrigin(err)
+	}
+	var zero T
+	empty, _ := json.Marshal(zero)
+	if loaded == nil && !sess.regenerated && bytes.Equal(data, empty) {
+		// nothing to save
+		return nil
+	}
+
+	touch := m.IdleTimeout / 2
+	if touch > time.Minute {
+		touch = time.Minute
+	}
+	if loaded != nil && !rotate && !sess.regenerated &&
+		bytes.Equal(data, loaded.Data) && now.Sub(sess.seen) < touch {
+		return nil
+	}
+
+	env := sessionEnvelope{
+		ID:      sess.id,
+		Created: sess.created.Unix(),
+		Seen:    now.Unix(),
+		Data:    data,
+	}
+	payload, _ := json.Marshal(env)
+	ttl := sess.created.Add(m.MaxAge).Sub(now)
+	if m.Store != nil {
+		if err = m.Store.Save(ctx, sess.id, payload, ttl); err != nil {
+			return E500Session.SetOrigin(err)
+		}
+		payload = []byte(sess.id)
+	}
+
+	value := m.seal(m.Keys[0], payload)
+	if len(value) > 4000 {
+		return E500Session.SetOrigin(errors.New("session is too large for cookie"))
+	}
+	http.SetCookie(r.W(), m.cookie(value, ttl))
+	return nil
+}
+
+// Middleware is the *real* middleware part of SessionManager
+func (m SessionManager[T]) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	// safe to set struct member as it is passed by value
+	if m.CookieName == "" {
+		m.CookieName = "session"
+	}
+	if m.Path == "" {
+		m.Path = "/"
+	}
+	if m.SameSite == 0 {
+		m.SameSite = http.SameSiteLaxMode
+	}
+	if m.IdleTimeout <= 0 {
+		m.IdleTimeout = 30 * time.Minute
+	}
+	if m.MaxAge <= 0 {
+		m.MaxAge = 24 * time.Hour
+	}
+	var keyErr error
+	if len(m.Keys) == 0 {
+		keyErr = ErrSessionKey
+	}
+	for _, k := range m.Keys {
+		if len(k) == 0 {
+			keyErr = ErrSessionKey
+		}
+	}
+
+	return func(r jsonapi.Request) (interface{}, error) {
+		if keyErr != nil {
+			return nil, E500Session.SetOrigin(keyErr)
+		}
+		now := time.Now()
+		sess, loaded, rotate := m.load(r.R(), now)
+		data, err := h(r.WithValue(sessionKey{}, sess))
+		if e := m.save(r, sess, loaded, rotate, now); e != nil && err == nil {
+			err = e
+		}
+		return data, err
+	}
+}
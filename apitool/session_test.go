@@ -0,0 +1,185 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+type testSession struct {
+	User  string `json:"user,omitempty"`
+	Count int    `json:"count,omitempty"`
+}
+
+func sessionHandler(r jsonapi.Request) (interface{}, error) {
+	sess := SessionOf[testSession](r)
+	switch r.R().URL.Path {
+	case "/login":
+		sess.Regenerate()
+		sess.Data.User = r.R().URL.Query().Get("user")
+	case "/logout":
+		sess.Destroy()
+	case "/count":
+		sess.Data.Count++
+	}
+	return map[string]interface{}{"id": sess.ID(), "data": sess.Data, "new": sess.IsNew()}, nil
+}
+
+type sessionReply struct {
+	Data struct {
+		ID   string      `json:"id"`
+		Data testSession `json:"data"`
+		New  bool        `json:"new"`
+	} `json:"data"`
+}
+
+func runSession(t *testing.T, h jsonapi.Handler, uri string, cookie *http.Cookie) (sessionReply, *http.Cookie) {
+	t.Helper()
+	r := httptest.NewRequest("GET", uri, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Fatalf("unexpected response: %d %s", w.Code, w.Body.String())
+	}
+
+	var ret sessionReply
+	if err := json.Unmarshal(w.Body.Bytes(), &ret); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "session" {
+			if c.MaxAge < 0 {
+				return ret, nil
+			}
+			return ret, c
+		}
+	}
+	return ret, cookie
+}
+
+func TestSession(t *testing.T) {
+	key := []byte("key")
+	store := &MemorySessionStore{}
+	modes := map[string]SessionManager[testSession]{
+		"signed":    {Keys: [][]byte{key}},
+		"encrypted": {Keys: [][]byte{key}, Encrypt: true},
+		"store":     {Keys: [][]byte{key}, Store: store},
+	}
+
+	for name, m := range modes {
+		t.Run(name, func(t *testing.T) {
+			h := jsonapi.Handler(m.Middleware(sessionHandler))
+
+			res, cookie := runSession(t, h, "/", nil)
+			if !res.Data.New || cookie != nil {
+				t.Fatalf("empty session should not be saved: %+v %v", res, cookie)
+			}
+
+			res, cookie = runSession(t, h, "/count", nil)
+			if cookie == nil || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+				t.Fatalf("unexpected cookie: %v", cookie)
+			}
+			if name == "encrypted" && strings.Contains(cookie.Value, ".") {
+				t.Errorf("cookie is not encrypted: %s", cookie.Value)
+			}
+			id := res.Data.ID
+
+			res, cookie = runSession(t, h, "/count", cookie)
+			if res.Data.New || res.Data.ID != id || res.Data.Data.Count != 2 {
+				t.Fatalf("unexpected session: %+v", res)
+			}
+
+			// regenerate on login
+			old := cookie
+			res, cookie = runSession(t, h, "/login?user=alice", cookie)
+			if res.Data.ID == id || res.Data.Data != (testSession{User: "alice", Count: 2}) {
+				t.Fatalf("unexpected session: %+v", res)
+			}
+			if name == "store" {
+				if res, _ = runSession(t, h, "/", old); !res.Data.New {
+					t.Errorf("old session id should be removed")
+				}
+			}
+
+			// tampered
+			bad := *cookie
+			bad.Value = "x" + bad.Value[1:]
+			if res, _ = runSession(t, h, "/", &bad); !res.Data.New {
+				t.Errorf("tampered cookie should be rejected")
+			}
+
+			// logout
+			res, cookie = runSession(t, h, "/logout", cookie)
+			if cookie != nil || res.Data.Data.User != "" {
+				t.Fatalf("session should be destroyed: %+v %v", res, cookie)
+			}
+		})
+	}
+}
+
+func TestSessionRotation(t *testing.T) {
+	oldKey, newKey := []byte("old"), []byte("new")
+	old := jsonapi.Handler(SessionManager[testSession]{Keys: [][]byte{oldKey}}.Middleware(sessionHandler))
+	rotated := jsonapi.Handler(SessionManager[testSession]{Keys: [][]byte{newKey, oldKey}}.Middleware(sessionHandler))
+	fresh := jsonapi.Handler(SessionManager[testSession]{Keys: [][]byte{newKey}}.Middleware(sessionHandler))
+
+	_, cookie := runSession(t, old, "/count", nil)
+	res, resigned := runSession(t, rotated, "/", cookie)
+	if res.Data.New || resigned == cookie {
+		t.Fatalf("expected cookie to be re-signed: %+v", res)
+	}
+	if res, _ = runSession(t, fresh, "/", resigned); res.Data.New || res.Data.Data.Count != 1 {
+		t.Fatalf("unexpected session: %+v", res)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	m := SessionManager[testSession]{Keys: [][]byte{[]byte("key")}, Store: &MemorySessionStore{}}
+	h := jsonapi.Handler(m.Middleware(sessionHandler))
+	m.CookieName = "session"
+
+	now := time.Now()
+	cases := map[string]sessionEnvelope{
+		"valid":    {ID: "a", Created: now.Add(-time.Hour).Unix(), Seen: now.Unix()},
+		"idle":     {ID: "b", Created: now.Add(-time.Hour).Unix(), Seen: now.Add(-31 * time.Minute).Unix()},
+		"absolute": {ID: "c", Created: now.Add(-25 * time.Hour).Unix(), Seen: now.Unix()},
+	}
+	for name, env := range cases {
+		t.Run(name, func(t *testing.T) {
+			env.Data = json.RawMessage(`{"count":5}`)
+			payload, _ := json.Marshal(env)
+			m.Store.Save(context.TODO(), env.ID, payload, time.Hour)
+			cookie := &http.Cookie{Name: "session", Value: m.seal([]byte("key"), []byte(env.ID))}
+
+			res, _ := runSession(t, h, "/", cookie)
+			if res.Data.New != (name != "valid") {
+				t.Fatalf("unexpected session: %+v", res)
+			}
+		})
+	}
+}
+
+func TestSessionInvalidKeys(t *testing.T) {
+	for _, keys := range [][][]byte{nil, {[]byte("key"), {}}} {
+		h := SessionManager[testSession]{Keys: keys}.Middleware(sessionHandler)
+		req := httptest.NewRequest("GET", "/count", nil)
+		_, err := h(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+		if !errors.Is(err, ErrSessionKey) {
+			t.Errorf("expected ErrSessionKey for %q, got %v", keys, err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// Priority classifies requests for ConcurrencyLimiter
+type Priority int
+
+const (
+	// PriorityNormal requests wait in queue if limit is reached
+	PriorityNormal Priority = iota
+	// PriorityLow requests are rejected immediately if limit is reached
+	PriorityLow
+	// PriorityCritical requests bypass the limiter, like health checks
+	PriorityCritical
+)
+
+// ConcurrencyOption defines supported parameters used by NewConcurrencyLimiter
+type ConcurrencyOption struct {
+	// max number of in-flight requests, REQUIRED
+	Max int
+	// max number of requests waiting for a slot, 0 means no waiting at all
+	Queue int
+	// max time to wait in queue, defaults to 1 second
+	Wait time.Duration
+	// value of Retry-After header when rejecting, defaults to 1 second
+	RetryAfter time.Duration
+	// classifies requests, every request is PriorityNormal if nil
+	Priority func(jsonapi.Request) Priority
+
+	// enables adaptive mode if > 0: the limit is decreased when a request
+	// takes longer than Target, and is increased slowly otherwise
+	Target time.Duration
+	// lower bound of the limit in adaptive mode, defaults to 1
+	Min int
+}
+
+// E503Overload is a predefined error indicates server is too busy
+var E503Overload = jsonapi.E503.SetData("server is overloaded")
+
+// ConcurrencyLimiter caps number of in-flight requests, and rejects the
+// requests with E503Overload and Retry-After header if there's no free slot.
+//
+// The state is shared among all handlers wrapped by its Middleware, so
+//
+//	// at most 100 requests in total
+//	l := apitool.NewConcurrencyLimiter(apitool.ConcurrencyOption{Max: 100})
+//	jsonapi.With(l.Middleware).Register(mux, apis)
+//
+//	// at most 100 requests for each api
+//	jsonapi.With(apitool.ConcurrencyPerRoute(
+//	    apitool.ConcurrencyOption{Max: 100},
+//	)).Register(mux, apis)
+type ConcurrencyLimiter struct {
+	opt ConcurrencyOption
+
+	lock     sync.Mutex
+	limit    float64
+	inflight int
+	waiters  []chan struct{}
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter
+func NewConcurrencyLimiter(opt ConcurrencyOption) *ConcurrencyLimiter {
+	if opt.Wait <= 0 {
+		opt.Wait = time.Second
+	}
+	if opt.RetryAfter <= 0 {
+		opt.RetryAfter = time.Second
+	}
+	if opt.Min <= 0 {
+		opt.Min = 1
+	}
+	if opt.Max < opt.Min {
+		opt.Max = opt.Min
+	}
+	return &ConcurrencyLimiter{
+		opt:   opt,
+		limit: float64(opt.Max),
+	}
+}
+
+// ConcurrencyPerRoute creates a middleware which creates a new
+// ConcurrencyLimiter for every wrapped handler
+func ConcurrencyPerRoute(opt ConcurrencyOption) jsonapi.Middleware {
+	return func(h jsonapi.Handler) jsonapi.Handler {
+		return NewConcurrencyLimiter(opt).Middleware(h)
+	}
+}
+
+// Limit returns current limit, which can be less than Max in adaptive mode
+func (l *ConcurrencyLimiter) Limit() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int(l.limit)
+}
+
+// InFlight returns number of running requests, excluding PriorityCritical ones
+func (l *ConcurrencyLimiter) InFlight() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.inflight
+}
+
+func (l *ConcurrencyLimiter) acquire(ctx context.Context, queue bool) bool {
+	l.lock.Lock()
+	if l.inflight < int(l.limit) {
+		l.inflight++
+		l.lock.Unlock()
+		return true
+	}
+	if !queue || len(l.waiters) >= l.opt.Queue {
+		l.lock.Unlock()
+		return false
+	}
+	ch := make(chan struct{})
+	l.waiters = append(l.waiters, ch)
+	l.lock.Unlock()
+
+	timer := time.NewTimer(l.opt.Wait)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for idx, w := range l.waiters {
+		if w == ch {
+			l.waiters = append(l.waiters[:idx], l.waiters[idx+1:]...)
+			return false
+		}
+	}
+	// slot has been handed to us right before removing from the queue, give
+	// it back
+	l.releaseLocked()
+	return false
+}
+
+func (l *ConcurrencyLimiter) releaseLocked() {
+	l.inflight--
+	for len(l.waiters) > 0 && l.inflight < int(l.limit) {
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+		l.inflight++
+	}
+}
+
+func (l *ConcurrencyLimiter) release(elapsed time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.opt.Target > 0 {
+		// AIMD: increase by 1 every "limit" fast requests, decrease by 10%
+		// for each slow request
+		if elapsed > l.opt.Target {
+			l.limit *= 0.9
+		} else {
+			l.limit += 1 / l.limit
+		}
+		if l.limit < float64(l.opt.Min) {
+			l.limit = float64(l.opt.Min)
+		}
+		if l.limit > float64(l.opt.Max) {
+			l.limit = float64(l.opt.Max)
+		}
+	}
+
+	l.releaseLocked()
+}
+
+// Middleware is the *real* middleware part of ConcurrencyLimiter
+func (l *ConcurrencyLimiter) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	return func(r jsonapi.Request) (data interface{}, err error) {
+		p := PriorityNormal
+		if l.opt.Priority != nil {
+			p = l.opt.Priority(r)
+		}
+		if p == PriorityCritical {
+			return h(r)
+		}
+
+		if !l.acquire(r.R().Context(), p == PriorityNormal) {
+			r.W().Header().Set("Retry-After", ceilSeconds(l.opt.RetryAfter))
+			return nil, E503Overload
+		}
+
+		begin := time.Now()
+		defer func() { l.release(time.Since(begin)) }()
+		return h(r)
+	}
+}
@@ -0,0 +1,110 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	block := make(chan struct{})
+	l := NewConcurrencyLimiter(ConcurrencyOption{
+		Max:   1,
+		Queue: 1,
+		Wait:  time.Second,
+		Priority: func(r jsonapi.Request) Priority {
+			if r.R().URL.Path == "/health" {
+				return PriorityCritical
+			}
+			return PriorityNormal
+		},
+	})
+	h := l.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		if r.R().URL.Path == "/block" {
+			<-block
+		}
+		return nil, nil
+	})
+	run := func(path string) chan error {
+		ret := make(chan error, 1)
+		go func() {
+			req := httptest.NewRequest("GET", path, nil)
+			_, err := h(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+			ret <- err
+		}()
+		return ret
+	}
+	waitFor := func(f func() bool) {
+		for x := 0; x < 100 && !f(); x++ {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	first := run("/block")
+	waitFor(func() bool { return l.InFlight() == 1 })
+	queued := run("/")
+	waitFor(func() bool {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+		return len(l.waiters) == 1
+	})
+
+	if err := <-run("/"); err == nil {
+		t.Fatal("expected to be rejected when queue is full")
+	}
+	if err := <-run("/health"); err != nil {
+		t.Fatalf("critical request should bypass the limiter: %v", err)
+	}
+
+	close(block)
+	if err := <-first; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := <-queued; err != nil {
+		t.Fatalf("queued request should be processed: %v", err)
+	}
+	if x := l.InFlight(); x != 0 {
+		t.Fatalf("expected no in-flight request, got %d", x)
+	}
+}
+
+func TestConcurrencyLimiterAdaptive(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyOption{
+		Max:    10,
+		Min:    2,
+		Target: time.Millisecond,
+	})
+	slow := l.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		time.Sleep(2 * time.Millisecond)
+		return nil, nil
+	})
+	fast := l.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		return nil, nil
+	})
+	run := func(h jsonapi.Handler) {
+		h(jsonapi.FromHTTP(
+			httptest.NewRecorder(),
+			httptest.NewRequest("GET", "/", nil),
+		))
+	}
+
+	for x := 0; x < 30; x++ {
+		run(slow)
+	}
+	if x := l.Limit(); x != 2 {
+		t.Fatalf("expected limit to be decreased to 2, got %d", x)
+	}
+
+	for x := 0; x < 100; x++ {
+		run(fast)
+	}
+	if x := l.Limit(); x <= 2 {
+		t.Fatalf("expected limit to be increased, got %d", x)
+	}
+}
@@ -0,0 +1,204 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+	"github.com/raohwork/jsonapi/apitool/callapi"
+)
+
+var (
+	// E401Signature indicates the request is not signed or the signature is
+	// invalid
+	E401Signature = jsonapi.E401.SetData("invalid request signature")
+	// E413Signature indicates the body of signed request is too large
+	E413Signature = jsonapi.E413.SetData("request body is too large")
+)
+
+// NonceCache remembers nonces of signed requests to prevent replay attack
+//
+// Implement it to share nonces across processes, with redis SET NX for
+// example.
+type NonceCache interface {
+	// Add atomically saves nonce for ttl, returns false if it exists
+	Add(ctx context.Context, nonce string, ttl time.Duration) (added bool, err error)
+}
+
+// MemoryNonceCache is an in-memory NonceCache. Zero value is ready to use.
+type MemoryNonceCache struct {
+	lock    sync.Mutex
+	entries map[string]time.Time
+	ops     int
+}
+
+// Add implements NonceCache
+func (c *MemoryNonceCache) Add(_ context.Context, nonce string, ttl time.Duration) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = map[string]time.Time{}
+	}
+	if c.ops++; c.ops >= 1024 {
+		c.ops = 0
+		for k, exp := range c.entries {
+			if now.After(exp) {
+				delete(c.entries, k)
+			}
+		}
+	}
+
+	if exp, ok := c.entries[nonce]; ok && now.Before(exp) {
+		return false, nil
+	}
+	c.entries[nonce] = now.Add(ttl)
+	return true, nil
+}
+
+// SignatureVerifier is a middleware verifies requests signed by
+// callapi.Signer
+//
+//	jsonapi.With(apitool.SignatureVerifier{
+//	    Keys: func(id string) ([]byte, error) { return secrets[id], nil },
+//	}.Middleware).Register(mux, apis)
+//
+// Body is read into memory to compute the digest, and replaced with
+// jsonapi.ReplaceBody so handler can still decode it.
+//
+// Nonces are saved only after the signature is verified, and are kept for
+// twice of MaxSkew, so a captured request cannot be replayed.
+type SignatureVerifier struct {
+	// finds secret by key id, REQUIRED. Return empty secret or an error if the
+	// key id is unknown. Empty secret is never accepted.
+	Keys func(keyID string) ([]byte, error)
+	// allowed clock skew, defaults to 5 minutes
+	MaxSkew time.Duration
+	// defaults to a new MemoryNonceCache for each Middleware call
+	Nonces NonceCache
+	// max size of request body, defaults to 10MB
+	MaxBodySize int64
+	// headers must be signed in addition to host, case-insensitive
+	RequiredHeaders []string
+}
+
+type signatureParams struct {
+	keyID, ts, nonce string
+	headers          []string
+	sig              []byte
+}
+
+func parseSignature(v string) (ret signatureParams, ok bool) {
+	for _, p := range strings.Split(v, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(p), "=")
+		switch key {
+		case "keyid":
+			ret.keyID = val
+		case "ts":
+			ret.ts = val
+		case "nonce":
+			ret.nonce = val
+		case "headers":
+			ret.headers = strings.Split(val, ";")
+		case "sig":
+			ret.sig, _ = base64.RawURLEncoding.DecodeString(val)
+		}
+	}
+	ok = ret.ts != "" && ret.nonce != "" && len(ret.sig) > 0
+	return
+}
+
+func (p signatureParams) signs(header string) bool {
+	header = strings.ToLower(header)
+	for _, h := range p.headers {
+		if h == header {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware is the *real* middleware part of SignatureVerifier
+func (v SignatureVerifier) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	// safe to set struct member as it is passed by value
+	if v.MaxSkew <= 0 {
+		v.MaxSkew = 5 * time.Minute
+	}
+	if v.Nonces == nil {
+		v.Nonces = &MemoryNonceCache{}
+	}
+	if v.MaxBodySize <= 0 {
+		v.MaxBodySize = 10 << 20
+	}
+
+	return func(r jsonapi.Request) (interface{}, error) {
+		req := r.R()
+		p, ok := parseSignature(req.Header.Get(callapi.SignatureHeader))
+		if !ok {
+			return nil, E401Signature
+		}
+		if !p.signs("host") {
+			return nil, E401Signature.SetData("host must be signed")
+		}
+		for _, x := range v.RequiredHeaders {
+			if !p.signs(x) {
+				return nil, E401Signature.SetData(x + " must be signed")
+			}
+		}
+
+		ts, err := strconv.ParseInt(p.ts, 10, 64)
+		if err != nil {
+			return nil, E401Signature.SetOrigin(err)
+		}
+		if d := time.Since(time.Unix(ts, 0)); d > v.MaxSkew || d < -v.MaxSkew {
+			return nil, E401Signature.SetData("request timestamp is out of range")
+		}
+
+		secret, err := v.Keys(p.keyID)
+		if err != nil || len(secret) == 0 {
+			return nil, E401Signature.SetOrigin(err)
+		}
+
+		var body []byte
+		if req.Body != nil {
+			body, err = io.ReadAll(io.LimitReader(req.Body, v.MaxBodySize+1))
+			if err != nil {
+				return nil, jsonapi.E400.SetOrigin(err).SetData("failed to read request body")
+			}
+			if int64(len(body)) > v.MaxBodySize {
+				return nil, E413Signature
+			}
+			req.Body.Close()
+			r = jsonapi.ReplaceBody(r, io.NopCloser(bytes.NewReader(body)))
+		}
+
+		digest := sha256.Sum256(body)
+		canonical := callapi.CanonicalRequest(req, p.headers, p.ts, p.nonce, digest[:])
+		if !hmac.Equal(callapi.ComputeSignature(secret, canonical), p.sig) {
+			return nil, E401Signature
+		}
+
+		added, err := v.Nonces.Add(req.Context(), p.keyID+":"+p.nonce, 2*v.MaxSkew)
+		if err != nil {
+			return nil, jsonapi.E500.SetOrigin(err).SetData("failed to save nonce")
+		}
+		if !added {
+			return nil, E401Signature.SetData("request has been replayed")
+		}
+
+		return h(r)
+	}
+}
@@ -0,0 +1,152 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+	"github.com/raohwork/jsonapi/apitool/callapi"
+)
+
+func TestSignature(t *testing.T) {
+	secrets := map[string][]byte{"old": []byte("old secret"), "new": []byte("new secret")}
+	verifier := SignatureVerifier{
+		Keys: func(id string) ([]byte, error) {
+			if s, ok := secrets[id]; ok {
+				return s, nil
+			}
+			if id == "empty" {
+				return []byte{}, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+		MaxBodySize: 64,
+	}
+	server := httptest.NewServer(jsonapi.Handler(verifier.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		var param map[string]string
+		if err := r.Decode(&param); err != nil {
+			return nil, err
+		}
+		return param["msg"] + r.R().URL.Query().Get("q"), nil
+	})))
+	defer server.Close()
+
+	send := func(t *testing.T, req *http.Request) (code int, body string) {
+		t.Helper()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(buf))
+	}
+	create := func(t *testing.T, s callapi.Signer, uri string, param interface{}) *http.Request {
+		t.Helper()
+		req, err := callapi.NewEP("POST", server.URL+uri).With(s.Sign)(context.TODO(), param)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	t.Run("rotation", func(t *testing.T) {
+		for id := range secrets {
+			req := create(t, callapi.Signer{KeyID: id, Secret: secrets[id]}, "/?q=1", map[string]string{"msg": id})
+			if code, body := send(t, req); code != 200 || body != `{"data":"`+id+`1"}` {
+				t.Errorf("unexpected result for %s: %d %s", id, code, body)
+			}
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		req := create(t, callapi.Signer{KeyID: "new", Secret: secrets["new"]}, "/", map[string]string{"msg": "a"})
+		again := req.Clone(context.TODO())
+		again.Body, _ = req.GetBody()
+		if code, body := send(t, req); code != 200 {
+			t.Fatalf("unexpected result: %d %s", code, body)
+		}
+		if code, body := send(t, again); code != 401 || !strings.Contains(body, "replayed") {
+			t.Fatalf("unexpected result: %d %s", code, body)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		req := create(t, callapi.Signer{KeyID: "new", Secret: secrets["new"]}, "/?q=1", map[string]string{"msg": "a"})
+		req.URL.RawQuery = "q=2"
+		if code, _ := send(t, req); code != 401 {
+			t.Fatalf("expected 401, got %d", code)
+		}
+	})
+
+	t.Run("unknown-key", func(t *testing.T) {
+		req := create(t, callapi.Signer{KeyID: "x", Secret: secrets["new"]}, "/", nil)
+		if code, _ := send(t, req); code != 401 {
+			t.Fatalf("expected 401, got %d", code)
+		}
+	})
+
+	t.Run("empty-secret", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", server.URL, nil)
+		if _, err := (callapi.Signer{KeyID: "empty"}).Sign(req); err != callapi.ErrSignSecret {
+			t.Fatalf("expected ErrSignSecret, got %v", err)
+		}
+
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		digest := sha256.Sum256(nil)
+		sig := callapi.ComputeSignature(nil, callapi.CanonicalRequest(req, []string{"host"}, ts, "n", digest[:]))
+		req.Header.Set(callapi.SignatureHeader, "keyid=empty,ts="+ts+",nonce=n,headers=host,sig="+
+			base64.RawURLEncoding.EncodeToString(sig))
+		if code, _ := send(t, req); code != 401 {
+			t.Fatalf("expected 401, got %d", code)
+		}
+	})
+
+	t.Run("too-large", func(t *testing.T) {
+		req := create(t, callapi.Signer{KeyID: "new", Secret: secrets["new"]}, "/", map[string]string{"msg": strings.Repeat("a", 64)})
+		if code, _ := send(t, req); code != 413 {
+			t.Fatalf("expected 413, got %d", code)
+		}
+	})
+
+	t.Run("skew", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", server.URL, nil)
+		ts := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+		headers := []string{"host"}
+		digest := sha256.Sum256(nil)
+		sig := callapi.ComputeSignature(secrets["new"], callapi.CanonicalRequest(req, headers, ts, "n", digest[:]))
+		req.Header.Set(callapi.SignatureHeader, "keyid=new,ts="+ts+",nonce=n,headers=host,sig="+
+			base64.RawURLEncoding.EncodeToString(sig))
+		if code, body := send(t, req); code != 401 || !strings.Contains(body, "out of range") {
+			t.Fatalf("unexpected result: %d %s", code, body)
+		}
+	})
+}
+
+func TestCanonicalRequestKeepsHeader(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Add("X-Test", " a ")
+	req.Header.Add("X-Test", "b ")
+	digest := sha256.Sum256(nil)
+	canonical := callapi.CanonicalRequest(req, []string{"x-test"}, "1", "n", digest[:])
+
+	if !strings.Contains(canonical, "x-test:a,b") {
+		t.Errorf("expected trimmed values, got %q", canonical)
+	}
+	if x := req.Header.Values("X-Test"); x[0] != " a " || x[1] != "b " {
+		t.Errorf("expected header to be kept, got %q", x)
+	}
+}
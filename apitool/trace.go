@@ -0,0 +1,306 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raohwork/jsonapi"
+)
+
+// TraceID is the trace-id defined in W3C trace context
+type TraceID [16]byte
+
+// IsValid reports whether t is not all zero
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// MarshalText implements encoding.TextMarshaler
+func (t TraceID) MarshalText() ([]byte, error) { return []byte(t.String()), nil }
+
+// SpanID is the parent-id (span id) defined in W3C trace context
+type SpanID [8]byte
+
+// IsValid reports whether s is not all zero
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// MarshalText implements encoding.TextMarshaler
+func (s SpanID) MarshalText() ([]byte, error) { return []byte(s.String()), nil }
+
+// SpanContext is the propagated part of a span, see
+// https://www.w3.org/TR/trace-context/
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// trace-flags, only the sampled flag (0x01) is defined for now
+	Flags byte
+	// content of tracestate header, passed as-is
+	State string
+}
+
+// Sampled reports whether the sampled flag is set
+func (c SpanContext) Sampled() bool { return c.Flags&1 == 1 }
+
+// IsValid reports whether both TraceID and SpanID are valid
+func (c SpanContext) IsValid() bool { return c.TraceID.IsValid() && c.SpanID.IsValid() }
+
+// Traceparent formats c as value of traceparent header
+func (c SpanContext) Traceparent() string {
+	return "00-" + c.TraceID.String() + "-" + c.SpanID.String() + "-" +
+		hex.EncodeToString([]byte{c.Flags})
+}
+
+// ErrTraceparent indicates the traceparent header is malformed
+var ErrTraceparent = errors.New("malformed traceparent")
+
+func decodeHex(dst []byte, src string) bool {
+	if len(src) != len(dst)*2 || strings.ToLower(src) != src {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(src))
+	return err == nil
+}
+
+// ParseTraceparent parses value of traceparent header
+//
+// Future versions are accepted as long as first four fields are parsable.
+func ParseTraceparent(v string) (ret SpanContext, err error) {
+	err = ErrTraceparent
+	f := strings.Split(strings.TrimSpace(v), "-")
+	if len(f) < 4 {
+		return
+	}
+
+	var ver [1]byte
+	if !decodeHex(ver[:], f[0]) || ver[0] == 0xff {
+		return
+	}
+	if ver[0] == 0 && len(f) != 4 {
+		return
+	}
+
+	var flags [1]byte
+	if !decodeHex(ret.TraceID[:], f[1]) ||
+		!decodeHex(ret.SpanID[:], f[2]) ||
+		!decodeHex(flags[:], f[3]) {
+		return SpanContext{}, err
+	}
+	if !ret.IsValid() {
+		return SpanContext{}, err
+	}
+
+	ret.Flags = flags[0]
+	return ret, nil
+}
+
+type traceKey struct{}
+
+// ContextWithTrace creates a new context carrying sc
+//
+// It is useful when you have to start a trace outside of Tracer middleware, a
+// background job for example.
+func ContextWithTrace(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, traceKey{}, sc)
+}
+
+// TraceFromContext retrieves current span context stored by Tracer middleware
+// or ContextWithTrace
+func TraceFromContext(ctx context.Context) (sc SpanContext, ok bool) {
+	sc, ok = ctx.Value(traceKey{}).(SpanContext)
+	return
+}
+
+// NewTrace creates a sampled SpanContext with random ids
+func NewTrace() (ret SpanContext) {
+	rand.Read(ret.TraceID[:])
+	rand.Read(ret.SpanID[:])
+	ret.Flags = 1
+	return
+}
+
+// InjectTrace adds traceparent and tracestate headers to req, using the span
+// context stored in req.Context(). It is designed to be used with
+// callapi.Endpoint.With:
+//
+//	ep := callapi.NewEP("POST", uri).With(apitool.InjectTrace)
+//	err := ep.DefaultCaller().Call(r.R().Context(), param, &result)
+//
+// It does nothing if there's no span context.
+func InjectTrace(req *http.Request) (*http.Request, error) {
+	sc, ok := TraceFromContext(req.Context())
+	if !ok || !sc.IsValid() {
+		return req, nil
+	}
+
+	req.Header.Set("traceparent", sc.Traceparent())
+	if sc.State != "" {
+		req.Header.Set("tracestate", sc.State)
+	}
+	return req, nil
+}
+
+// Span represents a finished span
+type Span struct {
+	Name    string  `json:"name"`
+	TraceID TraceID `json:"trace_id"`
+	SpanID  SpanID  `json:"span_id"`
+	// zero if this is a root span
+	ParentID   SpanID            `json:"parent_id"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// SpanExporter receives finished spans. Export might be called concurrently.
+type SpanExporter interface {
+	Export(s Span) error
+}
+
+// MemoryExporter is a SpanExporter keeps spans in memory, mostly used in tests.
+// Zero value is ready to use.
+type MemoryExporter struct {
+	lock  sync.Mutex
+	spans []Span
+}
+
+// Export implements SpanExporter
+func (e *MemoryExporter) Export(s Span) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, s)
+	return nil
+}
+
+// Spans returns a copy of exported spans
+func (e *MemoryExporter) Spans() []Span {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]Span(nil), e.spans...)
+}
+
+// Reset removes all exported spans
+func (e *MemoryExporter) Reset() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = nil
+}
+
+// JSONLinesExporter is a SpanExporter writes spans to W in JSON lines format
+type JSONLinesExporter struct {
+	W    io.Writer
+	lock sync.Mutex
+}
+
+// Export implements SpanExporter
+func (e *JSONLinesExporter) Export(s Span) error {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	_, err = e.W.Write(buf)
+	return err
+}
+
+// FileExporter is a JSONLinesExporter writes to a file
+type FileExporter struct {
+	JSONLinesExporter
+	f *os.File
+}
+
+// NewFileExporter opens (or creates) the file in append mode and writes spans
+// to it in JSON lines format
+func NewFileExporter(fn string) (*FileExporter, error) {
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{
+		JSONLinesExporter: JSONLinesExporter{W: f},
+		f:                 f,
+	}, nil
+}
+
+// Close closes the file
+func (e *FileExporter) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.f.Close()
+}
+
+// Tracer is a middleware to propagate W3C trace context
+//
+// It parses traceparent and tracestate headers, or starts a new trace if
+// missing or malformed, and starts a span around the handler. The span context
+// is stored in request context, see TraceFromContext and InjectTrace.
+//
+// Only sampled spans are sent to Exporter.
+type Tracer struct {
+	// REQUIRED
+	Exporter SpanExporter
+	// computes span name, defaults to "METHOD pattern"
+	Name func(r *http.Request) string
+}
+
+// Middleware is the *real* middleware part of Tracer
+func (t Tracer) Middleware(h jsonapi.Handler) jsonapi.Handler {
+	return func(r jsonapi.Request) (data interface{}, err error) {
+		req := r.R()
+		sc := NewTrace()
+		parent, e := ParseTraceparent(req.Header.Get("traceparent"))
+		if e == nil {
+			sc.TraceID = parent.TraceID
+			sc.Flags = parent.Flags
+			sc.State = req.Header.Get("tracestate")
+		}
+
+		span := Span{
+			TraceID:  sc.TraceID,
+			SpanID:   sc.SpanID,
+			ParentID: parent.SpanID,
+			Start:    time.Now(),
+		}
+
+		r = r.WithValue(traceKey{}, sc)
+		data, err = h(r)
+
+		if !sc.Sampled() {
+			return
+		}
+		span.End = time.Now()
+		span.Name = req.Method + " " + jsonapi.PatternOf(req)
+		if t.Name != nil {
+			span.Name = t.Name(req)
+		}
+		status, code := statusOf(data, err)
+		span.Attributes = map[string]string{
+			"http.method":      req.Method,
+			"http.route":       jsonapi.PatternOf(req),
+			"http.status_code": strconv.Itoa(status),
+		}
+		if code != "" {
+			span.Attributes["jsonapi.error_code"] = code
+		}
+		t.Exporter.Export(span)
+		return
+	}
+}
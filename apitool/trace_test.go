@@ -0,0 +1,96 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/raohwork/jsonapi"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	cases := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"valid", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"future", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xx", true},
+		{"extra", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-xx", false},
+		{"ff", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"upper", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"zero-trace", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"zero-span", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"short", "00-4bf92f3577b34da6a3ce929d0e0e4736-01", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(c.value)
+			if c.ok != (err == nil) {
+				t.Fatalf("unexpected result: %v", err)
+			}
+			if c.ok && c.value[:2] == "00" && sc.Traceparent() != c.value {
+				t.Fatalf("expected %s, got %s", c.value, sc.Traceparent())
+			}
+		})
+	}
+}
+
+func TestTracer(t *testing.T) {
+	exp := &MemoryExporter{}
+	var out *http.Request
+	h := Tracer{Exporter: exp}.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		req, _ := http.NewRequestWithContext(r.R().Context(), "GET", "http://a.b", nil)
+		out, _ = InjectTrace(req)
+		return nil, jsonapi.E404
+	})
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", parent)
+	req.Header.Set("tracestate", "a=b")
+	h(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+
+	spans := exp.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	s := spans[0]
+	if x := s.TraceID.String(); x != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("unexpected trace id: %s", x)
+	}
+	if x := s.ParentID.String(); x != "00f067aa0ba902b7" {
+		t.Errorf("unexpected parent id: %s", x)
+	}
+	if x := s.Attributes["http.status_code"]; x != "404" {
+		t.Errorf("unexpected status: %s", x)
+	}
+
+	expect := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + s.SpanID.String() + "-01"
+	if x := out.Header.Get("traceparent"); x != expect {
+		t.Errorf("expected outgoing traceparent %s, got %s", expect, x)
+	}
+	if x := out.Header.Get("tracestate"); x != "a=b" {
+		t.Errorf("unexpected outgoing tracestate: %s", x)
+	}
+}
+
+func TestTracerNotSampled(t *testing.T) {
+	exp := &MemoryExporter{}
+	h := Tracer{Exporter: exp}.Middleware(func(r jsonapi.Request) (interface{}, error) {
+		return nil, nil
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	h(jsonapi.FromHTTP(httptest.NewRecorder(), req))
+
+	if l := len(exp.Spans()); l != 0 {
+		t.Fatalf("expected no span, got %d", l)
+	}
+}
@@ -0,0 +1,330 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"bufio"
+	"encoding"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/raohwork/jsonapi"
+)
+
+var (
+	// E400Upload indicates the request is not a valid multipart form
+	E400Upload = jsonapi.E400.SetData("invalid multipart form")
+	// E413Upload indicates uploaded file or whole request is too large
+	E413Upload = jsonapi.E413.SetData("upload size exceeds limit")
+	// E415Upload indicates type of uploaded file is not allowed
+	E415Upload = jsonapi.E415.SetData("file type is not allowed")
+)
+
+// FilePart describes an uploaded file
+type FilePart struct {
+	// name of the form field
+	Field    string
+	Filename string
+	// detected by sniffing the content, see http.DetectContentType
+	ContentType string
+	// header of the part, including Content-Type sent by client
+	Header textproto.MIMEHeader
+}
+
+// FileSink receives uploaded file. It should consume body, or the file is
+// skipped.
+type FileSink func(f FilePart, body io.Reader) error
+
+// Uploader parses multipart/form-data request in streaming way, so files are
+// never buffered entirely in memory.
+//
+//	var uploader = apitool.Uploader{
+//	    MaxFileSize:  10 << 20,
+//	    AllowedTypes: []string{"image/png", "image/jpeg"},
+//	}
+//
+//	func uploadAvatar(r jsonapi.Request) (interface{}, error) {
+//	    var form struct {
+//	        UserID int64 `form:"user_id"`
+//	    }
+//	    var key string
+//	    err := uploader.Parse(r, &form, func(f apitool.FilePart, body io.Reader) (err error) {
+//	        key, err = saveToStorage(f.ContentType, body)
+//	        return
+//	    })
+//	    if err != nil {
+//	        return nil, err
+//	    }
+//	    return key, updateAvatar(form.UserID, key)
+//	}
+type Uploader struct {
+	// max size of a file, defaults to 32MB
+	MaxFileSize int64
+	// max size of whole request body, defaults to 128MB
+	MaxTotalSize int64
+	// max total size of non-file fields, defaults to 1MB
+	MaxFieldSize int64
+	// allowed content types, "image/*" matches all images. Everything is
+	// allowed if empty.
+	AllowedTypes []string
+}
+
+func (u Uploader) allowed(ct string) bool {
+	if len(u.AllowedTypes) == 0 {
+		return true
+	}
+	for _, t := range u.AllowedTypes {
+		if t == ct || t == "*/*" {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok && strings.HasPrefix(ct, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+var errUploadLimit = errors.New("upload size exceeds limit")
+
+// countReader returns errUploadLimit if more than n bytes are read
+type countReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (c *countReader) Read(b []byte) (int, error) {
+	if c.n <= 0 {
+		// check if there's more data
+		var x [1]byte
+		if n, _ := c.r.Read(x[:]); n > 0 {
+			c.exceeded = true
+			return 0, errUploadLimit
+		}
+		return 0, io.EOF
+	}
+	if int64(len(b)) > c.n {
+		b = b[:c.n]
+	}
+	n, err := c.r.Read(b)
+	c.n -= int64(n)
+	return n, err
+}
+
+// Parse reads multipart form from r. Files are passed to sink in the order of
+// the request, and other fields are decoded into form after all parts are
+// processed. form can be nil if there's no field, or it can be a url.Values.
+// sink can be nil if no file is expected, file parts are rejected with
+// E400Upload in that case.
+//
+// Returns E400Upload if the request is malformed, E413Upload if any limit is
+// exceeded, E415Upload if a file is not allowed, or the error returned by sink.
+//
+// Fields are decoded with "form" tag, or "json" tag if not presented. Supported
+// field types are string, bool, numbers, encoding.TextUnmarshaler and slices of
+// them.
+func (u Uploader) Parse(r jsonapi.Request, form interface{}, sink FileSink) error {
+	// safe to set struct member as it is passed by value
+	if u.MaxFileSize <= 0 {
+		u.MaxFileSize = 32 << 20
+	}
+	if u.MaxTotalSize <= 0 {
+		u.MaxTotalSize = 128 << 20
+	}
+	if u.MaxFieldSize <= 0 {
+		u.MaxFieldSize = 1 << 20
+	}
+
+	req := r.R()
+	if req.ContentLength > u.MaxTotalSize {
+		return E413Upload
+	}
+	ct, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || ct != "multipart/form-data" || params["boundary"] == "" {
+		return E400Upload.SetOrigin(err)
+	}
+	total := &countReader{r: req.Body, n: u.MaxTotalSize}
+	mr := multipart.NewReader(total, params["boundary"])
+
+	values := url.Values{}
+	fieldSize := u.MaxFieldSize
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if total.exceeded {
+				return E413Upload
+			}
+			return E400Upload.SetOrigin(err)
+		}
+
+		if part.FileName() == "" {
+			buf, err := io.ReadAll(io.LimitReader(part, fieldSize+1))
+			if err != nil {
+				if total.exceeded {
+					return E413Upload
+				}
+				return E400Upload.SetOrigin(err)
+			}
+			if fieldSize -= int64(len(buf)); fieldSize < 0 {
+				return E413Upload
+			}
+			values.Add(part.FormName(), string(buf))
+			continue
+		}
+		if sink == nil {
+			return E400Upload.SetData("file is not accepted")
+		}
+
+		file := &countReader{r: part, n: u.MaxFileSize}
+		br := bufio.NewReaderSize(file, 512)
+		head, err := br.Peek(512)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			if file.exceeded || total.exceeded {
+				return E413Upload
+			}
+			return E400Upload.SetOrigin(err)
+		}
+		detected, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+		if !u.allowed(detected) {
+			return E415Upload.SetData(fmt.Sprintf(
+				"file type %s of %s is not allowed", detected, part.FormName(),
+			))
+		}
+
+		err = sink(FilePart{
+			Field:       part.FormName(),
+			Filename:    part.FileName(),
+			ContentType: detected,
+			Header:      part.Header,
+		}, br)
+		if file.exceeded || total.exceeded {
+			return E413Upload
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if form == nil {
+		return nil
+	}
+	if v, ok := form.(*url.Values); ok {
+		*v = values
+		return nil
+	}
+	if err := DecodeForm(values, form); err != nil {
+		return jsonapi.E400.SetOrigin(err).SetData(err.Error())
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func setFormValue(v reflect.Value, s string) error {
+	if reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		x := reflect.New(v.Type().Elem())
+		if err := setFormValue(x.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(x)
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		x, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(x)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(x)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(x)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// DecodeForm decodes form values into struct pointed by v, see Uploader.Parse
+// for supported types.
+func DecodeForm(values url.Values, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("form must be a pointer to struct")
+	}
+	rv = rv.Elem()
+
+	for _, f := range reflect.VisibleFields(rv.Type()) {
+		if f.Anonymous || !f.IsExported() {
+			continue
+		}
+		name, ok := f.Tag.Lookup("form")
+		if !ok {
+			name = f.Tag.Get("json")
+		}
+		name, _, _ = strings.Cut(name, ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		vals, ok := values[name]
+		if !ok || len(vals) == 0 {
+			continue
+		}
+		fv, err := rv.FieldByIndexErr(f.Index)
+		if err != nil {
+			continue
+		}
+
+		if fv.Kind() == reflect.Slice && !reflect.PtrTo(fv.Type()).Implements(textUnmarshalerType) {
+			s := reflect.MakeSlice(fv.Type(), len(vals), len(vals))
+			for idx, x := range vals {
+				if err := setFormValue(s.Index(idx), x); err != nil {
+					return fmt.Errorf("invalid value of %s: %w", name, err)
+				}
+			}
+			fv.Set(s)
+			continue
+		}
+		if err := setFormValue(fv, vals[0]); err != nil {
+			return fmt.Errorf("invalid value of %s: %w", name, err)
+		}
+	}
+	return nil
+}
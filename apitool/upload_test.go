@@ -0,0 +1,180 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package apitool
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/raohwork/jsonapi"
+	"github.com/raohwork/jsonapi/apitool/callapi"
+)
+
+var pngHeader = []byte("\x89PNG\x0d\x0a\x1a\x0a")
+
+func TestUploader(t *testing.T) {
+	type form struct {
+		Name string  `form:"name"`
+		Tags []int   `json:"tags"`
+		Opt  *bool   `form:"opt"`
+		Skip float64 `form:"-"`
+	}
+
+	u := Uploader{MaxFileSize: 64, MaxTotalSize: 4096, AllowedTypes: []string{"image/*"}}
+	h := jsonapi.Handler(func(r jsonapi.Request) (interface{}, error) {
+		var f form
+		sizes := map[string]int64{}
+		err := u.Parse(r, &f, func(p FilePart, body io.Reader) error {
+			n, err := io.Copy(io.Discard, body)
+			sizes[p.Field+":"+p.ContentType] = n
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"form": f, "files": sizes}, nil
+	})
+
+	png := func(size int) callapi.MultipartFile {
+		buf := append(append([]byte{}, pngHeader...), bytes.Repeat([]byte{0}, size-len(pngHeader))...)
+		return callapi.MultipartFile{
+			Field: "img", Filename: "a.png", Body: bytes.NewReader(buf), Size: int64(size),
+		}
+	}
+
+	cases := []struct {
+		name   string
+		param  callapi.Multipart
+		status int
+		expect string
+		// Content-Length is unknown
+		chunked bool
+	}{
+		{
+			name: "ok",
+			param: callapi.Multipart{
+				Fields: url.Values{"name": {"john"}, "tags": {"1", "2"}, "opt": {"true"}},
+				Files:  []callapi.MultipartFile{png(64)},
+			},
+			status: 200,
+			expect: `{"data":{"files":{"img:image/png":64},"form":{"Name":"john","tags":[1,2],"Opt":true,"Skip":0}}}`,
+		},
+		{
+			name: "unknown-size",
+			param: callapi.Multipart{Files: []callapi.MultipartFile{{
+				Field: "img", Filename: "a.png",
+				Body: io.MultiReader(bytes.NewReader(pngHeader), strings.NewReader("data")),
+			}}},
+			status:  200,
+			expect:  `{"data":{"files":{"img:image/png":12},"form":{"Name":"","tags":null,"Opt":null,"Skip":0}}}`,
+			chunked: true,
+		},
+		{
+			name:   "file-too-large",
+			param:  callapi.Multipart{Files: []callapi.MultipartFile{png(65)}},
+			status: 413,
+			expect: `{"errors":[{"detail":"upload size exceeds limit"}]}`,
+		},
+		{
+			name: "type",
+			param: callapi.Multipart{Files: []callapi.MultipartFile{{
+				Field: "img", Filename: "a.png", ContentType: "image/png",
+				Body: strings.NewReader("plain text"), Size: -1,
+			}}},
+			status: 415,
+			expect: `{"errors":[{"detail":"file type text/plain of img is not allowed"}]}`,
+		},
+		{
+			name:   "invalid-field",
+			param:  callapi.Multipart{Fields: url.Values{"tags": {"x"}}},
+			status: 400,
+			expect: `{"errors":[{"detail":"invalid value of tags: strconv.ParseInt: parsing \"x\": invalid syntax"}]}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var sent, total int64
+			ep := callapi.MultipartEP("POST", "/", func(s, t int64) { sent, total = s, t })
+			req, err := ep(context.TODO(), c.param)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			req.Body.Close()
+
+			if w.Code != c.status {
+				t.Errorf("expected status %d, got %d", c.status, w.Code)
+			}
+			if actual := strings.TrimSpace(w.Body.String()); actual != c.expect {
+				t.Errorf("expected %s, got %s", c.expect, actual)
+			}
+			if c.chunked {
+				if req.ContentLength != -1 || total != -1 {
+					t.Errorf("expected unknown length, got %d, total %d", req.ContentLength, total)
+				}
+			} else if c.status == 200 && (sent != total || total != req.ContentLength) {
+				t.Errorf("unexpected progress: %d/%d, length %d", sent, total, req.ContentLength)
+			}
+		})
+	}
+}
+
+func TestUploaderNilSink(t *testing.T) {
+	h := jsonapi.Handler(func(r jsonapi.Request) (interface{}, error) {
+		var f url.Values
+		if err := (Uploader{}).Parse(r, &f, nil); err != nil {
+			return nil, err
+		}
+		return f, nil
+	})
+
+	cases := []struct {
+		name   string
+		param  callapi.Multipart
+		status int
+		expect string
+	}{
+		{
+			name:   "fields",
+			param:  callapi.Multipart{Fields: url.Values{"name": {"john"}}},
+			status: 200,
+			expect: `{"data":{"name":["john"]}}`,
+		},
+		{
+			name: "file",
+			param: callapi.Multipart{Files: []callapi.MultipartFile{{
+				Field: "img", Filename: "a.png", Body: bytes.NewReader(pngHeader),
+			}}},
+			status: 400,
+			expect: `{"errors":[{"detail":"file is not accepted"}]}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := callapi.MultipartEP("POST", "/", nil)(context.TODO(), c.param)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			req.Body.Close()
+
+			if w.Code != c.status {
+				t.Errorf("expected status %d, got %d", c.status, w.Code)
+			}
+			if actual := strings.TrimSpace(w.Body.String()); actual != c.expect {
+				t.Errorf("expected %s, got %s", c.expect, actual)
+			}
+		})
+	}
+}
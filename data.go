@@ -7,6 +7,7 @@ package jsonapi
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 )
 
@@ -34,7 +35,7 @@ type FakeRequest struct {
 
 // Decode implements Request
 func (r *FakeRequest) Decode(data interface{}) error {
-	return r.Decoder.Decode(data)
+	return decodeBody(r.Decoder, data)
 }
 
 // R implements Request
@@ -83,6 +84,15 @@ func (r *reqWrapper) R() *http.Request {
 	return r.req
 }
 
+func (r *reqWrapper) WithValue(key, val interface{}) Request {
+	return &reqWrapper{
+		Request: r.Request.WithValue(key, val),
+		req: r.req.WithContext(
+			context.WithValue(r.req.Context(), key, val),
+		),
+	}
+}
+
 // WrapRequest creates a new Request, with http request replaced
 func WrapRequest(q Request, r *http.Request) Request {
 	return &reqWrapper{
@@ -100,6 +110,13 @@ func (r *respWrapper) W() http.ResponseWriter {
 	return r.resp
 }
 
+func (r *respWrapper) WithValue(key, val interface{}) Request {
+	return &respWrapper{
+		Request: r.Request.WithValue(key, val),
+		resp:    r.resp,
+	}
+}
+
 // WrapResponse creates a new Request, with http response replaced
 func WrapResponse(q Request, w http.ResponseWriter) Request {
 	return &respWrapper{
@@ -107,3 +124,43 @@ func WrapResponse(q Request, w http.ResponseWriter) Request {
 		resp:    w,
 	}
 }
+
+type bodyWrapper struct {
+	Request
+	req *http.Request
+	dec *json.Decoder
+}
+
+func (r *bodyWrapper) Decode(data interface{}) error {
+	return decodeBody(r.dec, data)
+}
+
+func (r *bodyWrapper) R() *http.Request {
+	return r.req
+}
+
+func (r *bodyWrapper) WithValue(key, val interface{}) Request {
+	return &bodyWrapper{
+		Request: r.Request.WithValue(key, val),
+		req: r.req.WithContext(
+			context.WithValue(r.req.Context(), key, val),
+		),
+		dec: r.dec,
+	}
+}
+
+// ReplaceBody creates a new Request, with request body replaced
+//
+// Both Decode() and R().Body of returned Request read from body. It's useful
+// for middleware which has to inspect or transform the request body, like
+// verifying signature or decompressing.
+func ReplaceBody(q Request, body io.ReadCloser) Request {
+	req := new(http.Request)
+	*req = *q.R()
+	req.Body = body
+	return &bodyWrapper{
+		Request: q,
+		req:     req,
+		dec:     json.NewDecoder(body),
+	}
+}
@@ -0,0 +1,59 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package jsonapi
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReplaceBody(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`1`))
+	q := ReplaceBody(
+		FromHTTP(httptest.NewRecorder(), req),
+		io.NopCloser(strings.NewReader(`2`)),
+	).WithValue("key", "val")
+
+	var actual int
+	if err := q.Decode(&actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual != 2 {
+		t.Errorf("expected 2, got %d", actual)
+	}
+	if v := q.R().Context().Value("key"); v != "val" {
+		t.Errorf("expected context value to be kept, got %v", v)
+	}
+}
+
+func TestWrapRequestWithValue(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	alt := httptest.NewRequest("GET", "/alt", nil)
+	q := WrapRequest(FromHTTP(httptest.NewRecorder(), req), alt).
+		WithValue("key", "val")
+
+	if p := q.R().URL.Path; p != "/alt" {
+		t.Errorf("expected wrapped request to be kept, got %s", p)
+	}
+	if v := q.R().Context().Value("key"); v != "val" {
+		t.Errorf("expected context value to be kept, got %v", v)
+	}
+}
+
+func TestWrapResponseWithValue(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	alt := httptest.NewRecorder()
+	q := WrapResponse(FromHTTP(httptest.NewRecorder(), req), alt).
+		WithValue("key", "val")
+
+	if q.W() != alt {
+		t.Errorf("expected wrapped response writer to be kept")
+	}
+	if v := q.R().Context().Value("key"); v != "val" {
+		t.Errorf("expected context value to be kept, got %v", v)
+	}
+}
@@ -0,0 +1,29 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package jsonapi
+
+// Document can be returned by handler to add top-level members other than
+// "data" to the response, links for pagination for example.
+//
+//	func listArticles(q jsonapi.Request) (interface{}, error) {
+//	    return jsonapi.Document{
+//	        Data:  articles,
+//	        Links: map[string]string{"next": "/articles?offset=20"},
+//	        Meta:  map[string]interface{}{"total": 100},
+//	    }, nil
+//	}
+//
+// Will be encoded as
+//
+//	{"data":[...],"links":{"next":"/articles?offset=20"},"meta":{"total":100}}
+//
+// Both Document and *Document are supported. Included is used for compound
+// documents, see MarshalResource.
+type Document struct {
+	Data     interface{}            `json:"data"`
+	Links    map[string]string      `json:"links,omitempty"`
+	Meta     map[string]interface{} `json:"meta,omitempty"`
+	Included []*ResourceObject      `json:"included,omitempty"`
+}
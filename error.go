@@ -16,7 +16,10 @@ import (
 // For jsonapi.Error, Code will contains result of SetCode; Detail will be SetData
 //
 // For other error types, only Detail is set, as error.Error()
+//
+// ID is an unique identifier of this occurrence of the error, see SetID
 type ErrObj struct {
+	ID     string `json:"id,omitempty"`
 	Code   string `json:"code,omitempty"`
 	Detail string `json:"detail,omitempty"`
 }
@@ -26,7 +29,7 @@ type ErrObj struct {
 // If Code is set, an Error instance will be returned. errors.New(Detail) otherwise.
 func (o *ErrObj) AsError() error {
 	if o.Code != "" {
-		return Error{message: o.Detail, errCode: o.Code}
+		return Error{message: o.Detail, errCode: o.Code, id: o.ID}
 	}
 
 	return errors.New(o.Detail)
@@ -39,6 +42,7 @@ type Error struct {
 	message  string
 	location string // url for 3xx redirect
 	errCode  string
+	id       string
 }
 
 // Data retrieves user defined error message
@@ -51,15 +55,33 @@ func (h Error) ErrCode() string {
 	return h.errCode
 }
 
+// ID retrieves the identifier of this occurrence of the error
+func (h Error) ID() string {
+	return h.id
+}
+
+// SetID forks a new instance with an unique identifier of this occurrence of
+// the error, a request id for example. It is sent to client as "id" member of
+// the error object.
+func (h Error) SetID(id string) Error {
+	h.id = id
+	return h
+}
+
 // SetOrigin creates a new Error instance to preserve original error
 func (h Error) SetOrigin(err error) Error {
 	h.Origin = err
 	return h
 }
 
+// Unwrap returns Origin, so errors.Is and errors.As can find the original error
+func (h Error) Unwrap() error {
+	return h.Origin
+}
+
 // EqualTo tells if two Error instances represents same kind of error
 //
-// It compares all fields no matter exported or not, excepts Origin
+// It compares all fields no matter exported or not, excepts Origin and ID
 func (h Error) EqualTo(e Error) bool {
 	switch {
 	case e.errCode != h.errCode:
@@ -76,8 +98,10 @@ func (h Error) EqualTo(e Error) bool {
 }
 
 // SetData creates a new Error instance and set the error message or url according to the error code
+//
+// For 3xx codes except 304, data is treated as url to redirect to.
 func (h Error) SetData(data string) Error {
-	if h.Code >= 301 && h.Code <= 303 {
+	if h.Code >= 300 && h.Code < 400 && h.Code != 304 {
 		h.location = data
 		return h
 	}
@@ -116,6 +140,7 @@ func (h Error) String() string {
 
 func fromError(e *Error) *ErrObj {
 	return &ErrObj{
+		ID:     e.id,
 		Code:   e.errCode,
 		Detail: e.message,
 	}
@@ -136,6 +161,7 @@ var (
 	E303     = Error{Code: 303, message: "See other"}
 	E304     = Error{Code: 304, message: "Not modified"}
 	E307     = Error{Code: 307, message: "Resource has been moved to another location temporarily"}
+	E308     = Error{Code: 308, message: "Resource has been moved to another location permanently"}
 	E400     = Error{Code: 400, message: "Error parsing request"}
 	E401     = Error{Code: 401, message: "You have to be authorized before accessing this resource"}
 	E403     = Error{Code: 403, message: "You have no right to access this resource"}
@@ -143,10 +169,13 @@ var (
 	E408     = Error{Code: 408, message: "Request timeout"}
 	E409     = Error{Code: 409, message: "Conflict"}
 	E410     = Error{Code: 410, message: "Gone"}
+	E412     = Error{Code: 412, message: "Precondition failed"}
 	E413     = Error{Code: 413, message: "Request entity too large"}
 	E415     = Error{Code: 415, message: "Unsupported media type"}
 	E418     = Error{Code: 418, message: "I'm a teapot"}
+	E422     = Error{Code: 422, message: "Unprocessable content"}
 	E426     = Error{Code: 426, message: "Upgrade required"}
+	E428     = Error{Code: 428, message: "Precondition required"}
 	E429     = Error{Code: 429, message: "Too many requests"}
 	E500     = Error{Code: 500, message: "Internal server error"}
 	E501     = Error{Code: 501, message: "Not implemented"}
@@ -168,6 +197,8 @@ var (
 	//    - Set HTTP status code manually.
 	//    - Set necessary response headers manually.
 	//    - Take care not to be overwritten by middleware.
+	//
+	// To send a file or binary data, return a File instead.
 	ASIS = Error{Code: -1}
 )
 
@@ -0,0 +1,115 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package jsonapi
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+// File can be returned by handler to send a file or binary data instead of JSON
+//
+//	func download(q jsonapi.Request) (interface{}, error) {
+//	    f, err := os.Open("report.pdf")
+//	    if err != nil {
+//	        return nil, jsonapi.E404.SetOrigin(err)
+//	    }
+//	    info, _ := f.Stat()
+//	    return &jsonapi.File{
+//	        Name:    "report.pdf",
+//	        Content: f,
+//	        ModTime: info.ModTime(),
+//	    }, nil
+//	}
+//
+// If Content implements io.ReadSeeker, it is served by http.ServeContent, so
+// Range (including multi-range), If-Modified-Since, If-Range and other
+// conditional requests are supported. Otherwise it is copied to client as-is.
+// Content is closed after sending if it implements io.Closer.
+//
+// Both File and *File are supported. Middleware can use IsFile to detect it
+// and leave it alone.
+type File struct {
+	// file name used in Content-Disposition header, and to guess content type
+	Name string
+	// detected by extension of Name or content if empty
+	ContentType string
+	// size of Content, only used if Content is not an io.ReadSeeker. Zero or
+	// negative value means unknown, Content-Length is not sent then.
+	Size int64
+	// used in Last-Modified header and conditional requests if not zero
+	ModTime time.Time
+	// sets Content-Disposition to "inline" instead of "attachment"
+	Inline  bool
+	Content io.Reader
+}
+
+// IsFile reports whether v is a File or *File, see File
+func IsFile(v interface{}) bool {
+	_, ok := fileOf(v)
+	return ok
+}
+
+func fileOf(v interface{}) (*File, bool) {
+	switch x := v.(type) {
+	case File:
+		return &x, true
+	case *File:
+		return x, x != nil
+	}
+	return nil, false
+}
+
+func (f *File) serve(w http.ResponseWriter, r *http.Request) {
+	if c, ok := f.Content.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	hdr := w.Header()
+	ct := f.ContentType
+	if ct == "" {
+		ct = mime.TypeByExtension(path.Ext(f.Name))
+	}
+	if ct != "" {
+		hdr.Set("Content-Type", ct)
+	} else {
+		// let http.ServeContent detect it
+		hdr.Del("Content-Type")
+	}
+
+	disposition := "attachment"
+	if f.Inline {
+		disposition = "inline"
+	}
+	if f.Name != "" {
+		disposition = mime.FormatMediaType(disposition, map[string]string{
+			"filename": f.Name,
+		})
+	}
+	hdr.Set("Content-Disposition", disposition)
+
+	if rs, ok := f.Content.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, f.Name, f.ModTime, rs)
+		return
+	}
+
+	if ct == "" {
+		hdr.Set("Content-Type", "application/octet-stream")
+	}
+	if !f.ModTime.IsZero() {
+		hdr.Set("Last-Modified", f.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if f.Size > 0 {
+		hdr.Set("Content-Length", strconv.FormatInt(f.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != "HEAD" && f.Content != nil {
+		io.Copy(w, f.Content)
+	}
+}
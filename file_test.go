@@ -0,0 +1,109 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package jsonapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFile(t *testing.T) {
+	mod := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fac := func(f File) Handler {
+		return func(q Request) (interface{}, error) { return &f, nil }
+	}
+	seeker := File{Name: "a b.txt", ModTime: mod, Content: strings.NewReader("0123456789")}
+	stream := File{Name: "data", Size: 3, Inline: true, Content: io.MultiReader(strings.NewReader("xyz"))}
+	unsized := File{Name: "data", Content: io.MultiReader(strings.NewReader("xyz"))}
+
+	cases := []struct {
+		name   string
+		h      Handler
+		header http.Header
+		status int
+		body   string
+		expect http.Header
+	}{
+		{
+			name:   "full",
+			h:      fac(seeker),
+			status: 200,
+			body:   "0123456789",
+			expect: http.Header{
+				"Content-Type":        {"text/plain; charset=utf-8"},
+				"Content-Disposition": {`attachment; filename="a b.txt"`},
+				"Last-Modified":       {"Thu, 02 Jan 2020 03:04:05 GMT"},
+			},
+		},
+		{
+			name:   "range",
+			h:      fac(seeker),
+			header: http.Header{"Range": {"bytes=2-4"}},
+			status: 206,
+			body:   "234",
+			expect: http.Header{"Content-Range": {"bytes 2-4/10"}},
+		},
+		{
+			name:   "multi-range",
+			h:      fac(seeker),
+			header: http.Header{"Range": {"bytes=0-1,5-6"}},
+			status: 206,
+		},
+		{
+			name:   "not-modified",
+			h:      fac(seeker),
+			header: http.Header{"If-Modified-Since": {"Thu, 02 Jan 2020 03:04:05 GMT"}},
+			status: 304,
+		},
+		{
+			name:   "stream",
+			h:      fac(stream),
+			status: 200,
+			body:   "xyz",
+			expect: http.Header{
+				"Content-Type":        {"application/octet-stream"},
+				"Content-Disposition": {`inline; filename=data`},
+				"Content-Length":      {"3"},
+			},
+		},
+		{
+			name:   "unknown-size",
+			h:      fac(unsized),
+			status: 200,
+			body:   "xyz",
+			expect: http.Header{"Content-Length": {""}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			for k, v := range c.header {
+				r.Header[k] = v
+			}
+			w := httptest.NewRecorder()
+			c.h.ServeHTTP(w, r)
+
+			if w.Code != c.status {
+				t.Errorf("expected status %d, got %d", c.status, w.Code)
+			}
+			if c.body != "" && w.Body.String() != c.body {
+				t.Errorf("expected body %q, got %q", c.body, w.Body.String())
+			}
+			for k := range c.expect {
+				if actual := w.Header().Get(k); actual != c.expect.Get(k) {
+					t.Errorf("expected %s to be %q, got %q", k, c.expect.Get(k), actual)
+				}
+			}
+			if c.name == "multi-range" && !strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges") {
+				t.Errorf("unexpected content type: %s", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
@@ -5,8 +5,10 @@
 package jsonapi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 )
 
@@ -23,10 +25,15 @@ import (
 //         return doSomething(param), nil
 //     }
 //
-// To redirect clients, return 301~303 status code and set Data property
+// To redirect clients, return 3xx status code (except 304) and set Data property
 //
 //     return nil, jsonapi.E301.SetData("http://google.com")
 //
+// To send data with status code other than 200, or to set response headers,
+// wrap the data with Status
+//
+//     return jsonapi.Status(201, data).Header("Location", "/articles/1"), nil
+//
 // Redirecting depends on http.Redirect(). The data returned from handler will never
 // write to ResponseWriter.
 //
@@ -34,18 +41,57 @@ import (
 //
 //     - Return {"data": your_data} if error == nil
 //     - Return {"errors": [{"code": application-defined-error-code, "detail": message}]} if error returned
+//     - Return resource objects and compound document if data is a resource struct, see IsResource
+//     - Send the file as-is if data is a File
 type Handler func(r Request) (interface{}, error)
 
 // ServeHTTP implements net/http.Handler
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer track(r)()
 	w.Header().Set("Content-Type", "application/json")
-	enc := json.NewEncoder(w)
+	hook := &writerHook{w: w}
+	r = r.WithContext(context.WithValue(r.Context(), writerKey{}, hook))
 	res, err := h(FromHTTP(w, r))
+	status := http.StatusOK
+	if err == nil {
+		var hdr http.Header
+		status, hdr, res = UnwrapResult(res)
+		for k, v := range hdr {
+			w.Header()[k] = v
+		}
+		res, err = ResourceDocument(r, res)
+		if status < 100 || status > 999 {
+			// net/http panics with invalid status code
+			err = E500.SetData("invalid status code")
+		}
+	}
+	w = hook.w
+	defer hook.close()
+	if f, ok := fileOf(res); ok && err == nil {
+		f.serve(w, r)
+		return
+	}
+	enc := json.NewEncoder(w)
 	resp := make(map[string]interface{})
 	if err == nil {
-		resp["data"] = res
-		e := enc.Encode(resp)
+		var doc interface{} = resp
+		switch x := res.(type) {
+		case Document:
+			doc = x
+		case *Document:
+			doc = x
+		default:
+			resp["data"] = res
+		}
+		if !bodyAllowed(status) {
+			w.WriteHeader(status)
+			return
+		}
+		// marshal before writing status code, so we can still send 500
+		buf, e := json.Marshal(doc)
 		if e == nil {
+			w.WriteHeader(status)
+			w.Write(append(buf, '\n'))
 			return
 		}
 		delete(resp, "data")
@@ -73,8 +119,8 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		code = httperr.Code
-		if code >= 301 && code <= 303 && httperr.location != "" {
-			// 301~303 redirect
+		if code >= 300 && code < 400 && httperr.location != "" {
+			// 3xx redirect
 			http.Redirect(w, r, httperr.location, code)
 			return
 		}
@@ -89,3 +135,46 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	resp["errors"] = []*ErrObj{{Detail: err.Error()}}
 	enc.Encode(resp)
 }
+
+type writerKey struct{}
+
+// writerHook holds the response writer used by Handler.ServeHTTP
+type writerHook struct {
+	w       http.ResponseWriter
+	closers []io.Closer
+}
+
+func (h *writerHook) close() {
+	for x := len(h.closers) - 1; x >= 0; x-- {
+		h.closers[x].Close()
+	}
+}
+
+// WrapWriter creates a new Request, with response writer replaced by f(q.W())
+//
+// Unlike WrapResponse, the new writer is also used by Handler.ServeHTTP to write
+// the response, so middleware can transform whole response, compressing for
+// example. If the new writer implements io.Closer, it is closed after the
+// response is written.
+//
+// It's identical to WrapResponse if the handler is not served by
+// Handler.ServeHTTP.
+func WrapWriter(q Request, f func(http.ResponseWriter) http.ResponseWriter) Request {
+	w := f(q.W())
+	if hook, ok := q.R().Context().Value(writerKey{}).(*writerHook); ok {
+		hook.w = w
+		if c, ok := w.(io.Closer); ok {
+			hook.closers = append(hook.closers, c)
+		}
+	}
+
+	return WrapResponse(q, w)
+}
+
+// DetachContext returns a context which keeps values of ctx but is never
+// canceled, and is detached from the response writer of Handler.ServeHTTP, so
+// WrapWriter does not affect the finished response. Use it to run handlers in
+// background after the request is finished.
+func DetachContext(ctx context.Context) context.Context {
+	return context.WithValue(context.WithoutCancel(ctx), writerKey{}, nil)
+}
@@ -6,6 +6,7 @@ package jsonapi
 
 import (
 	"errors"
+	"net/http"
 	"net/http/httptest"
 	"testing"
 )
@@ -138,3 +139,147 @@ func TestHandler(t *testing.T) {
 		}
 	})
 }
+
+func TestHandlerDocument(t *testing.T) {
+	cases := []struct {
+		name string
+		data interface{}
+	}{
+		{"value", Document{
+			Data:  1,
+			Links: map[string]string{"next": "/a"},
+			Meta:  map[string]interface{}{"total": 2},
+		}},
+		{"pointer", &Document{
+			Data:  1,
+			Links: map[string]string{"next": "/a"},
+			Meta:  map[string]interface{}{"total": 2},
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := Handler(func(Request) (interface{}, error) { return c.data, nil })
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+			expect := `{"data":1,"links":{"next":"/a"},"meta":{"total":2}}` + "\n"
+			if actual := w.Body.String(); actual != expect {
+				t.Fatalf("expected %s, got %s", expect, actual)
+			}
+		})
+	}
+}
+
+func TestHandlerStatus(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     interface{}
+		err      error
+		status   int
+		body     string
+		location string
+	}{
+		{
+			name:     "201",
+			data:     Status(201, 1).Header("Location", "/a/1"),
+			status:   201,
+			body:     `{"data":1}` + "\n",
+			location: "/a/1",
+		},
+		{
+			name:   "202-document",
+			data:   Status(202, Document{Data: 1, Meta: map[string]interface{}{"a": 1}}),
+			status: 202,
+			body:   `{"data":1,"meta":{"a":1}}` + "\n",
+		},
+		{
+			name:   "204",
+			data:   Status(204, 1),
+			status: 204,
+		},
+		{
+			name:   "zero-code",
+			data:   Status(0, 1),
+			status: 200,
+			body:   `{"data":1}` + "\n",
+		},
+		{
+			name:   "zero-result",
+			data:   Result{},
+			status: 200,
+			body:   `{"data":null}` + "\n",
+		},
+		{
+			name:   "invalid-code",
+			data:   Status(42, 1),
+			status: 500,
+			body:   `{"errors":[{"detail":"invalid status code"}]}` + "\n",
+		},
+		{
+			name:   "marshal-error",
+			data:   Status(201, make(chan int)),
+			status: 500,
+			body:   `{"errors":[{"detail":"Failed to marshal data"}]}` + "\n",
+		},
+		{
+			name:     "307",
+			err:      E307.SetData("/b"),
+			status:   307,
+			location: "/b",
+		},
+		{
+			name:     "308",
+			err:      E308.SetData("/b"),
+			status:   308,
+			location: "/b",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Handler(func(Request) (interface{}, error) {
+				return c.data, c.err
+			}).ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+
+			if w.Code != c.status {
+				t.Errorf("expected status %d, got %d", c.status, w.Code)
+			}
+			if c.status != 307 && c.status != 308 && w.Body.String() != c.body {
+				t.Errorf("expected body %q, got %q", c.body, w.Body.String())
+			}
+			if l := w.Header().Get("Location"); l != c.location {
+				t.Errorf("expected location %q, got %q", c.location, l)
+			}
+		})
+	}
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.n += len(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func TestDetachContext(t *testing.T) {
+	cw := &countingWriter{}
+	h := Handler(func(r Request) (interface{}, error) {
+		req := r.R().WithContext(DetachContext(r.R().Context()))
+		WrapWriter(WrapRequest(r, req), func(w http.ResponseWriter) http.ResponseWriter {
+			cw.ResponseWriter = w
+			return cw
+		})
+		return 1, nil
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Body.Len() == 0 || cw.n != 0 {
+		t.Fatalf("expected response not to be written by detached writer, got %d bytes", cw.n)
+	}
+}
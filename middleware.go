@@ -62,7 +62,11 @@ func (r *registerer) Register(mux HTTPMux, apis []API) {
 func (r *registerer) RegisterAll(
 	mux HTTPMux, prefix string, handlers interface{}, conv func(string) string,
 ) {
-	r.Register(mux, findMatchedMethods(prefix, handlers, conv))
+	apis, policies := findMatchedMethods(prefix, handlers, conv)
+	if policies != nil {
+		mux = PolicyMux(mux, policies)
+	}
+	r.Register(mux, apis)
 }
 
 // With creaates a new Registerer and chains after current Registerer
@@ -0,0 +1,224 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package jsonapi
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Principal is the authenticated client, see Policy
+type Principal interface {
+	HasRole(role string) bool
+	HasScope(scope string) bool
+}
+
+// Policy declares who can access an API. It is declared by PolicyMux or
+// PolicySet, enforced by middleware like apitool.Authorizer, and listed by
+// Registry for auditing.
+//
+//	policies := map[string]*jsonapi.Policy{
+//	    "/login":       {Public: true},
+//	    "/orders":      {Scopes: []string{"orders:read"}},
+//	    "/admin/users": {Roles: []string{"admin"}},
+//	}
+//	jsonapi.With(auth).Register(jsonapi.PolicyMux(mux, policies), apis)
+//
+// A principal is allowed if it has all the roles and scopes, and Rule
+// returns nil. Non-public policy without roles, scopes and rule allows any
+// authenticated principal.
+type Policy struct {
+	// allows everyone, even if not authenticated. Other fields are ignored.
+	Public bool
+	Roles  []string
+	Scopes []string
+	// custom rule, only called if the principal has all roles and scopes
+	Rule func(p Principal, r Request) error
+	// describes Rule in String
+	RuleName string
+}
+
+// Allow checks if who is allowed by the policy, who is nil if not
+// authenticated. Error returned by Rule is passed through.
+func (p *Policy) Allow(who Principal, r Request) (bool, error) {
+	if p.Public {
+		return true, nil
+	}
+	if who == nil {
+		return false, nil
+	}
+	for _, x := range p.Roles {
+		if !who.HasRole(x) {
+			return false, nil
+		}
+	}
+	for _, x := range p.Scopes {
+		if !who.HasScope(x) {
+			return false, nil
+		}
+	}
+	if p.Rule != nil {
+		if err := p.Rule(who, r); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// String describes the policy like "roles=admin scopes=a,b rule=owner"
+func (p *Policy) String() string {
+	if p == nil {
+		return "undeclared"
+	}
+	if p.Public {
+		return "public"
+	}
+	ret := []string{}
+	if len(p.Roles) > 0 {
+		ret = append(ret, "roles="+strings.Join(p.Roles, ","))
+	}
+	if len(p.Scopes) > 0 {
+		ret = append(ret, "scopes="+strings.Join(p.Scopes, ","))
+	}
+	if p.Rule != nil {
+		name := p.RuleName
+		if name == "" {
+			name = "custom"
+		}
+		ret = append(ret, "rule="+name)
+	}
+	if len(ret) == 0 {
+		return "authenticated"
+	}
+	return strings.Join(ret, " ")
+}
+
+// PolicySet can be implemented by handlers passed to RegisterAll, to declare
+// policies of handler methods. The key of the map is method name.
+type PolicySet interface {
+	Policies() map[string]*Policy
+}
+
+// PolicyMux wraps mux to declare policies of APIs registered to it, keyed by
+// pattern. It works with Register, RegisterAll and any Registerer. To audit
+// routes with Registry, wrap the Registry:
+//
+//	reg := jsonapi.NewRegistry(nil)
+//	jsonapi.With(auth).Register(jsonapi.PolicyMux(reg, policies), apis)
+//
+// Handlers not registered by Register, RegisterAll or a Registerer are passed
+// to mux as-is. If mux is nil, http.Handle is used.
+func PolicyMux(mux HTTPMux, policies map[string]*Policy) HTTPMux {
+	return &policyMux{mux: mux, policies: policies}
+}
+
+type policyMux struct {
+	mux      HTTPMux
+	policies map[string]*Policy
+}
+
+func (m *policyMux) Handle(pattern string, h http.Handler) {
+	if x, ok := h.(*apiHandler); ok {
+		if p, ok := m.policies[pattern]; ok {
+			c := *x
+			c.policy = p
+			h = &c
+		}
+	}
+	if m.mux == nil {
+		http.Handle(pattern, h)
+		return
+	}
+	m.mux.Handle(pattern, h)
+}
+
+type policyKey struct{}
+
+// PolicyOf retrieves the policy of API which is serving the request
+//
+// It returns nil if the handler is not registered by Register, RegisterAll or
+// a Registerer, or policy is not declared by PolicyMux or PolicySet.
+func PolicyOf(r *http.Request) *Policy {
+	ret, _ := r.Context().Value(policyKey{}).(*Policy)
+	return ret
+}
+
+// Route is an entry registered to Registry
+type Route struct {
+	Pattern string
+	// nil if not declared
+	Policy *Policy
+	// false if it's a plain http.Handler, which is not a jsonapi handler
+	IsAPI bool
+}
+
+// Registry is an HTTPMux records registered routes, so you can list them
+// for auditing. Zero value is not usable, create it with NewRegistry.
+//
+//	reg := jsonapi.NewRegistry(nil)
+//	jsonapi.With(auth).Register(jsonapi.PolicyMux(reg, policies), apis)
+//	for _, r := range reg.Unprotected() {
+//	    log.Printf("WARNING: %s has no policy", r.Pattern)
+//	}
+//	srv := &jsonapi.Server{Handler: reg}
+type Registry struct {
+	mux    HTTPMux
+	lock   sync.Mutex
+	routes map[string]Route
+}
+
+// NewRegistry creates a Registry which registers handlers to mux, mux
+// defaults to a new http.ServeMux
+func NewRegistry(mux HTTPMux) *Registry {
+	if mux == nil {
+		mux = http.NewServeMux()
+	}
+	return &Registry{mux: mux, routes: map[string]Route{}}
+}
+
+// Handle implements HTTPMux
+func (reg *Registry) Handle(pattern string, h http.Handler) {
+	route := Route{Pattern: pattern}
+	if x, ok := h.(*apiHandler); ok {
+		route.Policy, route.IsAPI = x.policy, true
+	}
+
+	reg.mux.Handle(pattern, h)
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+	reg.routes[pattern] = route
+}
+
+// ServeHTTP implements http.Handler, it panics if the underlying mux is not
+// an http.Handler
+func (reg *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reg.mux.(http.Handler).ServeHTTP(w, r)
+}
+
+// Routes lists registered routes, sorted by pattern
+func (reg *Registry) Routes() []Route {
+	reg.lock.Lock()
+	ret := make([]Route, 0, len(reg.routes))
+	for _, r := range reg.routes {
+		ret = append(ret, r)
+	}
+	reg.lock.Unlock()
+
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Pattern < ret[j].Pattern })
+	return ret
+}
+
+// Unprotected lists routes without policy, sorted by pattern
+func (reg *Registry) Unprotected() []Route {
+	ret := []Route{}
+	for _, r := range reg.Routes() {
+		if r.Policy == nil {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,70 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package jsonapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type policyHandlers struct{}
+
+func (policyHandlers) Policies() map[string]*Policy {
+	return map[string]*Policy{"Public": {Public: true}}
+}
+
+func (policyHandlers) Public(Request) (interface{}, error)  { return nil, nil }
+func (policyHandlers) Private(Request) (interface{}, error) { return nil, nil }
+
+func TestRegistry(t *testing.T) {
+	admin := &Policy{Roles: []string{"admin"}, Scopes: []string{"a", "b"}, Rule: func(Principal, Request) error { return nil }, RuleName: "owner"}
+	var actual *Policy
+	h := func(r Request) (interface{}, error) {
+		actual = PolicyOf(r.R())
+		return nil, nil
+	}
+
+	reg := NewRegistry(nil)
+	Register(PolicyMux(reg, map[string]*Policy{"/admin": admin}), []API{
+		{"/admin", h},
+		{"/open", h},
+	})
+	RegisterAll(reg, "/m", policyHandlers{}, nil)
+	reg.Handle("/raw", http.NotFoundHandler())
+
+	expect := []struct {
+		pattern, policy string
+		api             bool
+	}{
+		{"/admin", "roles=admin scopes=a,b rule=owner", true},
+		{"/m/Private", "undeclared", true},
+		{"/m/Public", "public", true},
+		{"/open", "undeclared", true},
+		{"/raw", "undeclared", false},
+	}
+	routes := reg.Routes()
+	if len(routes) != len(expect) {
+		t.Fatalf("unexpected routes: %+v", routes)
+	}
+	for idx, e := range expect {
+		r := routes[idx]
+		if r.Pattern != e.pattern || r.Policy.String() != e.policy || r.IsAPI != e.api {
+			t.Errorf("expected %+v, got %s %s %v", e, r.Pattern, r.Policy, r.IsAPI)
+		}
+	}
+	if x := reg.Unprotected(); len(x) != 3 {
+		t.Errorf("unexpected unprotected routes: %+v", x)
+	}
+
+	reg.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/admin", nil))
+	if actual != admin {
+		t.Errorf("unexpected policy in context: %v", actual)
+	}
+	reg.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/open", nil))
+	if actual != nil {
+		t.Errorf("unexpected policy in context: %v", actual)
+	}
+}
@@ -5,6 +5,7 @@
 package jsonapi
 
 import (
+	"context"
 	"net/http"
 	"reflect"
 	"regexp"
@@ -19,6 +20,9 @@ type HTTPMux interface {
 }
 
 // API denotes how a json api handler registers to a servemux
+//
+// Policies are declared by registering to PolicyMux, or implementing PolicySet
+// if using RegisterAll.
 type API struct {
 	Pattern string
 	Handler func(Request) (interface{}, error)
@@ -32,10 +36,41 @@ func Register(mux HTTPMux, apis []API) {
 	}
 
 	for _, api := range apis {
-		reg(api.Pattern, Handler(api.Handler))
+		reg(api.Pattern, &apiHandler{
+			pattern: api.Pattern,
+			h:       Handler(api.Handler),
+		})
 	}
 }
 
+type patternKey struct{}
+
+// apiHandler saves pattern and policy in context, Registry also detects it to
+// find the policy
+type apiHandler struct {
+	pattern string
+	policy  *Policy
+	h       http.Handler
+}
+
+func (a *apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), patternKey{}, a.pattern)
+	if a.policy != nil {
+		ctx = context.WithValue(ctx, policyKey{}, a.policy)
+	}
+	a.h.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// PatternOf retrieves the pattern of API which is serving the request
+//
+// It returns empty string if the handler is not registered by Register,
+// RegisterAll or a Registerer. It's useful for middleware which needs low
+// cardinality label of the API, like metrics or rate limiting.
+func PatternOf(r *http.Request) string {
+	ret, _ := r.Context().Value(patternKey{}).(string)
+	return ret
+}
+
 var reCamelToUL *regexp.Regexp
 var reCamelToULExcepts *regexp.Regexp
 
@@ -48,12 +83,19 @@ func init() {
 	)
 }
 
+// findMatchedMethods lists handler methods, and policies declared by PolicySet
+// keyed by pattern
 func findMatchedMethods(
 	prefix string, handlers interface{}, conv func(string) string,
-) []API {
+) (ret []API, policies map[string]*Policy) {
 	v := reflect.ValueOf(handlers)
+	var declared map[string]*Policy
+	if x, ok := handlers.(PolicySet); ok {
+		declared = x.Policies()
+		policies = map[string]*Policy{}
+	}
 
-	ret := make([]API, 0, v.NumMethod())
+	ret = make([]API, 0, v.NumMethod())
 
 	for x, t := 0, v.Type(); x < v.NumMethod(); x++ {
 		h, ok := v.Method(x).Interface().(func(Request) (interface{}, error))
@@ -62,7 +104,8 @@ func findMatchedMethods(
 			continue
 		}
 
-		name := t.Method(x).Name
+		method := t.Method(x).Name
+		name := method
 		if conv != nil {
 			name = conv(name)
 		}
@@ -70,9 +113,12 @@ func findMatchedMethods(
 			Pattern: prefix + "/" + name,
 			Handler: h,
 		})
+		if p, ok := declared[method]; ok {
+			policies[prefix+"/"+name] = p
+		}
 	}
 
-	return ret
+	return
 }
 
 // RegisterAll helps you to register all handler methods
@@ -85,6 +131,8 @@ func findMatchedMethods(
 //
 // If converter is nil, name will leave unchanged.
 //
+// Policies of methods can be declared by implementing PolicySet.
+//
 // As Go1.21, [http.ServeMux] is more feature-rich: you can bind http handler to
 // specified http method. But it's too complicated to support this good cool feature
 // here.
@@ -92,7 +140,11 @@ func RegisterAll(
 	mux HTTPMux, prefix string, handlers interface{},
 	converter func(string) string,
 ) {
-	Register(mux, findMatchedMethods(prefix, handlers, converter))
+	apis, policies := findMatchedMethods(prefix, handlers, converter)
+	if policies != nil {
+		mux = PolicyMux(mux, policies)
+	}
+	Register(mux, apis)
 }
 
 // ConvertCamelToSnake is a helper to convert CamelCase to camel_case
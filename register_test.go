@@ -88,3 +88,22 @@ func TestRegisterOrder(t *testing.T) {
 		t.Fatalf("expected 12321, got %s", actual)
 	}
 }
+
+func TestPatternOf(t *testing.T) {
+	var actual string
+	h := func(req Request) (interface{}, error) {
+		actual = PatternOf(req.R())
+		return nil, nil
+	}
+
+	mux := http.NewServeMux()
+	Register(mux, []API{{Pattern: "/api/", Handler: h}})
+	mux.ServeHTTP(
+		httptest.NewRecorder(),
+		httptest.NewRequest("GET", "http://localhost/api/123", nil),
+	)
+
+	if actual != "/api/" {
+		t.Fatalf("expected /api/, got %s", actual)
+	}
+}
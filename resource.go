@@ -0,0 +1,749 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package jsonapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ResourceIdentifier identifies a resource object
+type ResourceIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// Relationship is a relationship object of a resource object
+//
+// Data is nil, a *ResourceIdentifier or a []ResourceIdentifier.
+type Relationship struct {
+	Data  interface{}       `json:"data"`
+	Links map[string]string `json:"links,omitempty"`
+}
+
+// ResourceObject is a resource object described in https://jsonapi.org
+type ResourceObject struct {
+	Type          string                   `json:"type"`
+	ID            string                   `json:"id,omitempty"`
+	Attributes    map[string]interface{}   `json:"attributes,omitempty"`
+	Relationships map[string]*Relationship `json:"relationships,omitempty"`
+	Links         map[string]string        `json:"links,omitempty"`
+}
+
+// ResourceLinker can be implemented by resource struct to add "links" member to
+// the resource object
+type ResourceLinker interface {
+	ResourceLinks() map[string]string
+}
+
+// RelationshipLinker can be implemented by resource struct to add "links"
+// member to the relationship object
+type RelationshipLinker interface {
+	RelationshipLinks(name string) map[string]string
+}
+
+type resField struct {
+	name      string
+	index     []int
+	omitempty bool
+}
+
+// resInfo is parsed jsonapi tags of a resource struct
+type resInfo struct {
+	typ   string
+	id    []int
+	attrs []resField
+	rels  []resField
+}
+
+var resInfoCache sync.Map // reflect.Type => *resInfo
+
+// resourceInfo parses jsonapi tags of t, returns nil if t is not a resource
+// struct
+func resourceInfo(t reflect.Type) *resInfo {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	if v, ok := resInfoCache.Load(t); ok {
+		return v.(*resInfo)
+	}
+
+	var ret *resInfo
+	info := &resInfo{}
+	for _, f := range reflect.VisibleFields(t) {
+		tag, ok := f.Tag.Lookup("jsonapi")
+		if !ok || !f.IsExported() {
+			continue
+		}
+		args := strings.Split(tag, ",")
+		if len(args) < 2 {
+			continue
+		}
+		switch args[0] {
+		case "primary":
+			info.typ, info.id = args[1], f.Index
+			ret = info
+		case "attr":
+			info.attrs = append(info.attrs, resField{
+				name:      args[1],
+				index:     f.Index,
+				omitempty: len(args) > 2 && args[2] == "omitempty",
+			})
+		case "relation":
+			info.rels = append(info.rels, resField{
+				name:  args[1],
+				index: f.Index,
+			})
+		}
+	}
+
+	resInfoCache.Store(t, ret)
+	return ret
+}
+
+// resourceElem returns the resource struct type of t, which can be a struct,
+// or pointer/slice/array of it
+func resourceElem(t reflect.Type) (reflect.Type, *resInfo) {
+	for {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array:
+			t = t.Elem()
+			continue
+		}
+		return t, resourceInfo(t)
+	}
+}
+
+// IsResource reports whether v is a resource struct, or pointer/slice of it
+//
+// A resource struct is a struct with a field tagged `jsonapi:"primary,type"`:
+//
+//	type Article struct {
+//	    ID       int64     `jsonapi:"primary,articles"`
+//	    Title    string    `jsonapi:"attr,title"`
+//	    Summary  string    `jsonapi:"attr,summary,omitempty"`
+//	    Author   *Person   `jsonapi:"relation,author"`
+//	    Comments []Comment `jsonapi:"relation,comments"`
+//	}
+//
+// Primary field must be a string or an integer. Related types must be resource
+// structs, too.
+func IsResource(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	_, info := resourceElem(reflect.TypeOf(v))
+	return info != nil
+}
+
+// ResourceFields lists names of attributes and relationships of every resource
+// type reachable from v, keyed by resource type. v is a resource struct, or
+// pointer/slice of it. It returns nil if v is not a resource.
+//
+// It is used to validate sparse fieldsets, see apitool.SparseFields.
+func ResourceFields(v interface{}) map[string][]string {
+	if v == nil {
+		return nil
+	}
+	if !IsResource(v) {
+		return nil
+	}
+
+	ret := map[string][]string{}
+	queue := []reflect.Type{reflect.TypeOf(v)}
+	for len(queue) > 0 {
+		t, info := resourceElem(queue[0])
+		queue = queue[1:]
+		if info == nil {
+			continue
+		}
+		if _, ok := ret[info.typ]; ok {
+			continue
+		}
+		names := []string{}
+		for _, f := range info.attrs {
+			names = append(names, f.name)
+		}
+		for _, f := range info.rels {
+			names = append(names, f.name)
+			queue = append(queue, t.FieldByIndex(f.index).Type)
+		}
+		ret[info.typ] = names
+	}
+	return ret
+}
+
+func formatID(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func parseID(v reflect.Value, id string) error {
+	if id == "" {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(id)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := strconv.ParseInt(id, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, err := strconv.ParseUint(id, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(x)
+	default:
+		return fmt.Errorf("jsonapi: unsupported id type %s", v.Type())
+	}
+	return nil
+}
+
+// includeTree is parsed include paths
+type includeTree map[string]includeTree
+
+// parseInclude parses and validates "include" parameter against t
+func parseInclude(t reflect.Type, include []string) (includeTree, error) {
+	ret := includeTree{}
+	for _, path := range include {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		cur, typ := ret, t
+		for _, name := range strings.Split(path, ".") {
+			st, info := resourceElem(typ)
+			if info == nil {
+				return nil, E400.SetData("invalid include path: " + path)
+			}
+			var f *resField
+			for idx := range info.rels {
+				if info.rels[idx].name == name {
+					f = &info.rels[idx]
+					break
+				}
+			}
+			if f == nil {
+				return nil, E400.SetData("invalid include path: " + path)
+			}
+			typ = st.FieldByIndex(f.index).Type
+
+			sub, ok := cur[name]
+			if !ok {
+				sub = includeTree{}
+				cur[name] = sub
+			}
+			cur = sub
+		}
+	}
+	return ret, nil
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+type resMarshaler struct {
+	included []*ResourceObject
+	seen     map[ResourceIdentifier]bool
+}
+
+// deref dereferences pointers, returns false if it is nil
+func deref(v reflect.Value) (reflect.Value, bool) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v, false
+		}
+		v = v.Elem()
+	}
+	return v, true
+}
+
+func identify(v reflect.Value) (ret ResourceIdentifier, err error) {
+	info := resourceInfo(v.Type())
+	if info == nil {
+		return ret, fmt.Errorf("jsonapi: %s is not a resource struct", v.Type())
+	}
+	id, err := v.FieldByIndexErr(info.id)
+	if err != nil {
+		return ret, fmt.Errorf("jsonapi: cannot get id of %s: %w", v.Type(), err)
+	}
+	return ResourceIdentifier{
+		Type: info.typ,
+		ID:   formatID(id),
+	}, nil
+}
+
+// links calls method of v, or *v if it is addressable
+func links(v reflect.Value, f func(interface{}) map[string]string) map[string]string {
+	if v.CanAddr() {
+		return f(v.Addr().Interface())
+	}
+	return f(v.Interface())
+}
+
+func (m *resMarshaler) object(v reflect.Value, inc includeTree) (*ResourceObject, error) {
+	info := resourceInfo(v.Type())
+	id, err := identify(v)
+	if err != nil {
+		return nil, err
+	}
+	ret := &ResourceObject{Type: id.Type, ID: id.ID}
+	ret.Links = links(v, func(x interface{}) map[string]string {
+		if l, ok := x.(ResourceLinker); ok {
+			return l.ResourceLinks()
+		}
+		return nil
+	})
+
+	for _, f := range info.attrs {
+		fv, err := v.FieldByIndexErr(f.index)
+		if err != nil {
+			// nil embedded pointer
+			continue
+		}
+		if f.omitempty && isEmptyValue(fv) {
+			continue
+		}
+		if ret.Attributes == nil {
+			ret.Attributes = map[string]interface{}{}
+		}
+		ret.Attributes[f.name] = fv.Interface()
+	}
+
+	for _, f := range info.rels {
+		fv, err := v.FieldByIndexErr(f.index)
+		if err != nil {
+			// nil embedded pointer
+			continue
+		}
+		if ret.Relationships == nil {
+			ret.Relationships = map[string]*Relationship{}
+		}
+		rel := &Relationship{}
+		rel.Links = links(v, func(x interface{}) map[string]string {
+			if l, ok := x.(RelationshipLinker); ok {
+				return l.RelationshipLinks(f.name)
+			}
+			return nil
+		})
+		ret.Relationships[f.name] = rel
+
+		sub, include := inc[f.name]
+		fv, ok := deref(fv)
+		if !ok {
+			continue
+		}
+		var related []reflect.Value
+		switch fv.Kind() {
+		case reflect.Slice, reflect.Array:
+			ids := make([]ResourceIdentifier, 0, fv.Len())
+			for x := 0; x < fv.Len(); x++ {
+				ev, ok := deref(fv.Index(x))
+				if !ok {
+					continue
+				}
+				id, err := identify(ev)
+				if err != nil {
+					return nil, err
+				}
+				ids = append(ids, id)
+				related = append(related, ev)
+			}
+			rel.Data = ids
+		default:
+			id, err := identify(fv)
+			if err != nil {
+				return nil, err
+			}
+			rel.Data = &id
+			related = append(related, fv)
+		}
+
+		if !include {
+			continue
+		}
+		for _, ev := range related {
+			id, _ := identify(ev)
+			if m.seen[id] {
+				continue
+			}
+			m.seen[id] = true
+			// reserve the slot so resources are ordered by first appearance
+			idx := len(m.included)
+			m.included = append(m.included, nil)
+			obj, err := m.object(ev, sub)
+			if err != nil {
+				return nil, err
+			}
+			m.included[idx] = obj
+		}
+	}
+	return ret, nil
+}
+
+// MarshalResource converts resource structs in v into a compound document
+//
+// v must be a resource struct, or pointer/slice of it. See IsResource for
+// details. Related resources are added to Included member according to
+// include, which are relationship paths like "author" or "comments.author".
+// An E400 is returned if any path is invalid.
+func MarshalResource(v interface{}, include []string) (ret Document, err error) {
+	if v == nil {
+		return
+	}
+	t, info := resourceElem(reflect.TypeOf(v))
+	if info == nil {
+		return ret, fmt.Errorf("jsonapi: %s is not a resource struct", t)
+	}
+	inc, err := parseInclude(t, include)
+	if err != nil {
+		return
+	}
+
+	m := &resMarshaler{seen: map[ResourceIdentifier]bool{}}
+	rv, ok := deref(reflect.ValueOf(v))
+	if !ok {
+		return
+	}
+
+	var primary []reflect.Value
+	if k := rv.Kind(); k == reflect.Slice || k == reflect.Array {
+		for x := 0; x < rv.Len(); x++ {
+			if ev, ok := deref(rv.Index(x)); ok {
+				primary = append(primary, ev)
+			}
+		}
+	} else {
+		primary = append(primary, rv)
+	}
+	for _, ev := range primary {
+		id, _ := identify(ev)
+		m.seen[id] = true
+	}
+
+	objs := make([]*ResourceObject, 0, len(primary))
+	for _, ev := range primary {
+		obj, err := m.object(ev, inc)
+		if err != nil {
+			return ret, err
+		}
+		objs = append(objs, obj)
+	}
+
+	if k := rv.Kind(); k == reflect.Slice || k == reflect.Array {
+		ret.Data = objs
+	} else {
+		ret.Data = objs[0]
+	}
+	ret.Included = m.included
+	return
+}
+
+// ResourceDocument converts data returned by handler into a compound document
+// if it is a resource (see IsResource), or a Document contains resource. The
+// "include" query parameter of r is used to build included resources.
+//
+// Other data is returned as-is. It's used by Handler.ServeHTTP, and middlewares
+// which need to know the encoded response.
+//
+// If r is passed to a Handler, Content-Type of the response is changed to
+// "application/vnd.api+json" when data is converted.
+func ResourceDocument(r *http.Request, data interface{}) (interface{}, error) {
+	var doc Document
+	switch x := data.(type) {
+	case Document:
+		doc = x
+	case *Document:
+		if x == nil {
+			return data, nil
+		}
+		doc = *x
+	default:
+		if !IsResource(data) {
+			return data, nil
+		}
+		doc.Data = data
+	}
+	if !IsResource(doc.Data) {
+		return data, nil
+	}
+
+	var include []string
+	if v := r.URL.Query().Get("include"); v != "" {
+		include = strings.Split(v, ",")
+	}
+	ret, err := MarshalResource(doc.Data, include)
+	if err != nil {
+		return nil, err
+	}
+	ret.Links, ret.Meta = doc.Links, doc.Meta
+	// copy it, or appending might modify the array of doc.Included
+	ret.Included = append(append(
+		make([]*ResourceObject, 0, len(doc.Included)+len(ret.Included)),
+		doc.Included...,
+	), ret.Included...)
+
+	// resource responses use the media type of JSON:API, unless handler has
+	// set another one
+	if hook, ok := r.Context().Value(writerKey{}).(*writerHook); ok {
+		if hdr := hook.w.Header(); hdr.Get("Content-Type") == "application/json" {
+			hdr.Set("Content-Type", "application/vnd.api+json")
+		}
+	}
+	return ret, nil
+}
+
+type rawRelationship struct {
+	Data json.RawMessage `json:"data"`
+}
+
+type rawResource struct {
+	Type          string                     `json:"type"`
+	ID            string                     `json:"id"`
+	Attributes    map[string]json.RawMessage `json:"attributes"`
+	Relationships map[string]rawRelationship `json:"relationships"`
+}
+
+type rawResDocument struct {
+	Data     json.RawMessage `json:"data"`
+	Included []rawResource   `json:"included"`
+}
+
+type resUnmarshaler struct {
+	included map[ResourceIdentifier]*rawResource
+	filling  map[ResourceIdentifier]bool
+}
+
+// ErrResourceType indicates type of resource object mismatches the struct
+var ErrResourceType = errors.New("jsonapi: unexpected resource type")
+
+// settableField is like FieldByIndex, but allocates nil embedded pointers
+func settableField(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return v, fmt.Errorf("jsonapi: cannot set unexported embedded pointer %s", v.Type())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+func (u *resUnmarshaler) fill(v reflect.Value, raw *rawResource) error {
+	info := resourceInfo(v.Type())
+	if raw.Type != info.typ {
+		return fmt.Errorf("%w %q", ErrResourceType, raw.Type)
+	}
+	fv, err := settableField(v, info.id)
+	if err != nil {
+		return err
+	}
+	if err = parseID(fv, raw.ID); err != nil {
+		return err
+	}
+
+	id := ResourceIdentifier{Type: raw.Type, ID: raw.ID}
+	u.filling[id] = true
+	defer delete(u.filling, id)
+
+	for _, f := range info.attrs {
+		buf, ok := raw.Attributes[f.name]
+		if !ok {
+			continue
+		}
+		fv, err := settableField(v, f.index)
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(buf, fv.Addr().Interface()); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range info.rels {
+		rel, ok := raw.Relationships[f.name]
+		if !ok {
+			continue
+		}
+		fv, err := settableField(v, f.index)
+		if err != nil {
+			return err
+		}
+		if err = u.relation(fv, rel.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// related creates a value of type t from resource identifier
+func (u *resUnmarshaler) related(t reflect.Type, id ResourceIdentifier) (reflect.Value, error) {
+	ptr := t.Kind() == reflect.Ptr
+	if ptr {
+		t = t.Elem()
+	}
+	v := reflect.New(t).Elem()
+	if resourceInfo(t) == nil {
+		return v, fmt.Errorf("jsonapi: %s is not a resource struct", t)
+	}
+	raw, ok := u.included[id]
+	if !ok || u.filling[id] {
+		raw = &rawResource{Type: id.Type, ID: id.ID}
+	}
+	if err := u.fill(v, raw); err != nil {
+		return v, err
+	}
+	if ptr {
+		return v.Addr(), nil
+	}
+	return v, nil
+}
+
+func (u *resUnmarshaler) relation(fv reflect.Value, buf json.RawMessage) error {
+	buf = bytes.TrimSpace(buf)
+	if len(buf) == 0 || bytes.Equal(buf, []byte("null")) {
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+
+	if fv.Kind() == reflect.Slice {
+		var ids []ResourceIdentifier
+		if err := json.Unmarshal(buf, &ids); err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(fv.Type(), 0, len(ids))
+		for _, id := range ids {
+			ev, err := u.related(fv.Type().Elem(), id)
+			if err != nil {
+				return err
+			}
+			s = reflect.Append(s, ev)
+		}
+		fv.Set(s)
+		return nil
+	}
+
+	var id ResourceIdentifier
+	if err := json.Unmarshal(buf, &id); err != nil {
+		return err
+	}
+	ev, err := u.related(fv.Type(), id)
+	if err != nil {
+		return err
+	}
+	fv.Set(ev)
+	return nil
+}
+
+// UnmarshalResource decodes a JSON:API document into v, reverting
+// MarshalResource
+//
+// v must be a pointer to resource struct, or pointer to slice of it. Related
+// resources are filled with data from "included" member if presented, or only
+// primary field is set otherwise.
+func UnmarshalResource(buf []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("jsonapi: UnmarshalResource requires non-nil pointer")
+	}
+	if t, info := resourceElem(rv.Type()); info == nil {
+		return fmt.Errorf("jsonapi: %s is not a resource struct", t)
+	}
+
+	var doc rawResDocument
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return err
+	}
+	u := &resUnmarshaler{
+		included: map[ResourceIdentifier]*rawResource{},
+		filling:  map[ResourceIdentifier]bool{},
+	}
+	for idx := range doc.Included {
+		x := &doc.Included[idx]
+		u.included[ResourceIdentifier{Type: x.Type, ID: x.ID}] = x
+	}
+
+	rv = rv.Elem()
+	data := bytes.TrimSpace(doc.Data)
+	if len(data) == 0 || bytes.Equal(data, []byte("null")) {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+
+	if rv.Kind() == reflect.Slice {
+		var list []rawResource
+		if err := json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(rv.Type(), len(list), len(list))
+		for idx := range list {
+			ev := s.Index(idx)
+			if ev.Kind() == reflect.Ptr {
+				ev.Set(reflect.New(ev.Type().Elem()))
+				ev = ev.Elem()
+			}
+			if err := u.fill(ev, &list[idx]); err != nil {
+				return err
+			}
+		}
+		rv.Set(s)
+		return nil
+	}
+
+	var raw rawResource
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	return u.fill(rv, &raw)
+}
+
+// decodeBody decodes request body into data, resource documents are decoded
+// with UnmarshalResource
+func decodeBody(dec *json.Decoder, data interface{}) error {
+	if !IsResource(data) {
+		return dec.Decode(data)
+	}
+
+	var buf json.RawMessage
+	if err := dec.Decode(&buf); err != nil {
+		return err
+	}
+	return UnmarshalResource(buf, data)
+}
@@ -0,0 +1,188 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package jsonapi
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type resPerson struct {
+	ID   string `jsonapi:"primary,people"`
+	Name string `jsonapi:"attr,name"`
+}
+
+type resComment struct {
+	ID     int        `jsonapi:"primary,comments"`
+	Body   string     `jsonapi:"attr,body"`
+	Author *resPerson `jsonapi:"relation,author"`
+}
+
+type resArticle struct {
+	ID       int64        `jsonapi:"primary,articles"`
+	Title    string       `jsonapi:"attr,title"`
+	Summary  string       `jsonapi:"attr,summary,omitempty"`
+	Author   *resPerson   `jsonapi:"relation,author"`
+	Comments []resComment `jsonapi:"relation,comments"`
+}
+
+func (a *resArticle) ResourceLinks() map[string]string {
+	return map[string]string{"self": "/articles/1"}
+}
+
+func resFixture() *resArticle {
+	p := &resPerson{ID: "9", Name: "john"}
+	return &resArticle{
+		ID:     1,
+		Title:  "hello",
+		Author: p,
+		Comments: []resComment{
+			{ID: 5, Body: "first", Author: p},
+			{ID: 6, Body: "second", Author: &resPerson{ID: "8", Name: "mary"}},
+		},
+	}
+}
+
+func TestResourceHandler(t *testing.T) {
+	h := Handler(func(r Request) (interface{}, error) {
+		return resFixture(), nil
+	})
+
+	cases := []struct {
+		query  string
+		status int
+		expect string
+	}{
+		{
+			query:  "",
+			status: 200,
+			expect: `{"data":{"type":"articles","id":"1","attributes":{"title":"hello"},"relationships":{"author":{"data":{"type":"people","id":"9"}},"comments":{"data":[{"type":"comments","id":"5"},{"type":"comments","id":"6"}]}},"links":{"self":"/articles/1"}}}`,
+		},
+		{
+			query:  "?include=comments.author",
+			status: 200,
+			expect: `{"data":{"type":"articles","id":"1","attributes":{"title":"hello"},"relationships":{"author":{"data":{"type":"people","id":"9"}},"comments":{"data":[{"type":"comments","id":"5"},{"type":"comments","id":"6"}]}},"links":{"self":"/articles/1"}},"included":[{"type":"comments","id":"5","attributes":{"body":"first"},"relationships":{"author":{"data":{"type":"people","id":"9"}}}},{"type":"people","id":"9","attributes":{"name":"john"}},{"type":"comments","id":"6","attributes":{"body":"second"},"relationships":{"author":{"data":{"type":"people","id":"8"}}}},{"type":"people","id":"8","attributes":{"name":"mary"}}]}`,
+		},
+		{
+			query:  "?include=editor",
+			status: 400,
+			expect: `{"errors":[{"detail":"invalid include path: editor"}]}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/"+c.query, nil))
+			if w.Code != c.status {
+				t.Errorf("expected status %d, got %d", c.status, w.Code)
+			}
+			if actual := strings.TrimSpace(w.Body.String()); actual != c.expect {
+				t.Errorf("expected %s, got %s", c.expect, actual)
+			}
+			ct := "application/vnd.api+json"
+			if c.status != 200 {
+				ct = "application/json"
+			}
+			if x := w.Header().Get("Content-Type"); x != ct {
+				t.Errorf("expected content type %s, got %s", ct, x)
+			}
+		})
+	}
+}
+
+type resStamp struct {
+	Created string `jsonapi:"attr,created"`
+}
+
+type resNote struct {
+	ID int `jsonapi:"primary,notes"`
+	*resStamp
+	*ResStamp
+}
+
+type ResStamp struct {
+	Updated string     `jsonapi:"attr,updated"`
+	Editor  *resPerson `jsonapi:"relation,editor"`
+}
+
+func TestResourceNilEmbedded(t *testing.T) {
+	doc, err := MarshalResource(&resNote{ID: 1}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	obj := doc.Data.(*ResourceObject)
+	if obj.ID != "1" || len(obj.Attributes) != 0 || len(obj.Relationships) != 0 {
+		t.Fatalf("unexpected result: %+v", obj)
+	}
+
+	body := strings.NewReader(`{"data":{"type":"notes","id":"1","attributes":{"updated":"now"}}}`)
+	var actual resNote
+	if err = FromHTTP(nil, httptest.NewRequest("POST", "/", body)).Decode(&actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual.ResStamp == nil || actual.Updated != "now" {
+		t.Fatalf("unexpected result: %+v", actual)
+	}
+}
+
+func TestResourceDocumentIncluded(t *testing.T) {
+	extra := make([]*ResourceObject, 1, 4)
+	extra[0] = &ResourceObject{Type: "people", ID: "7"}
+	doc := Document{Data: resFixture(), Included: extra}
+
+	ret, err := ResourceDocument(httptest.NewRequest("GET", "/?include=author", nil), doc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if x := ret.(Document).Included; len(x) != 2 || x[0].ID != "7" || x[1].ID != "9" {
+		t.Fatalf("unexpected included: %+v", x)
+	}
+	if x := extra[:2]; x[1] != nil {
+		t.Fatalf("array of caller is modified: %+v", x[1])
+	}
+}
+
+func TestResourceRoundTrip(t *testing.T) {
+	expect := resFixture()
+	doc, err := MarshalResource([]*resArticle{expect}, []string{"author", "comments.author"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	Handler(func(r Request) (interface{}, error) { return doc, nil }).
+		ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	var actual []*resArticle
+	if err = FromHTTP(nil, httptest.NewRequest("POST", "/", w.Body)).Decode(&actual); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual([]*resArticle{expect}, actual) {
+		t.Fatalf("expected %+v, got %+v", expect, actual[0])
+	}
+
+	var wrong resPerson
+	body := strings.NewReader(`{"data":{"type":"articles","id":"1"}}`)
+	if err = FromHTTP(nil, httptest.NewRequest("POST", "/", body)).Decode(&wrong); err == nil {
+		t.Fatal("expected type mismatch to be rejected")
+	}
+}
+
+func TestResourceFields(t *testing.T) {
+	expect := map[string][]string{
+		"articles": {"title", "summary", "author", "comments"},
+		"people":   {"name"},
+		"comments": {"body", "author"},
+	}
+	if actual := ResourceFields([]*resArticle{}); !reflect.DeepEqual(expect, actual) {
+		t.Errorf("expected %v, got %v", expect, actual)
+	}
+	if actual := ResourceFields(1); actual != nil {
+		t.Errorf("expected nil for non-resource, got %v", actual)
+	}
+}
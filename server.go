@@ -0,0 +1,251 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package jsonapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Server wraps http.Server with sane timeouts and graceful shutdown. Zero
+// value serves http.DefaultServeMux at ":http".
+//
+//	mux := http.NewServeMux()
+//	jsonapi.Register(mux, apis)
+//	srv := &jsonapi.Server{
+//	    Addr:     os.Getenv("LISTEN"),
+//	    Handler:  mux,
+//	    SetReady: health.SetReady,
+//	}
+//	srv.OnShutdown(func(ctx context.Context) error { return db.Close() })
+//	if err := srv.ListenAndServe(); err != nil {
+//	    log.Fatal(err)
+//	}
+//
+// Addr can be one of
+//
+//   - TCP address like ":8080" or "127.0.0.1:8080"
+//   - "unix:" followed by path of unix domain socket, stale socket file is removed
+//   - "systemd" to use first socket passed by systemd socket activation
+//   - "systemd:" followed by name of the socket (FileDescriptorName= in unit file)
+//
+// Timeouts default to sane values if zero, set to negative value to disable.
+type Server struct {
+	Addr    string
+	Handler http.Handler
+
+	// defaults to 10 seconds
+	ReadHeaderTimeout time.Duration
+	// defaults to 30 seconds
+	ReadTimeout time.Duration
+	// defaults to 60 seconds
+	WriteTimeout time.Duration
+	// defaults to 120 seconds
+	IdleTimeout time.Duration
+	// max time to drain in-flight requests, defaults to 30 seconds.
+	// Connections are closed forcibly after that.
+	ShutdownTimeout time.Duration
+	// time to wait after SetReady(false) before closing listener, so load
+	// balancer has a chance to notice it
+	DrainDelay time.Duration
+	// called with false when shutting down, apitool.Health.SetReady for
+	// example
+	SetReady func(ready bool)
+	// signals triggering graceful shutdown in ListenAndServe, defaults to
+	// SIGINT and SIGTERM
+	Signals []os.Signal
+
+	lock     sync.Mutex
+	srv      *http.Server
+	hooks    []func(context.Context) error
+	inFlight atomic.Int64
+}
+
+type serverKey struct{}
+
+func timeoutOr(v, def time.Duration) time.Duration {
+	if v == 0 {
+		return def
+	}
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+func (s *Server) server() *http.Server {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.srv == nil {
+		s.srv = &http.Server{
+			Handler:           s.Handler,
+			ReadHeaderTimeout: timeoutOr(s.ReadHeaderTimeout, 10*time.Second),
+			ReadTimeout:       timeoutOr(s.ReadTimeout, 30*time.Second),
+			WriteTimeout:      timeoutOr(s.WriteTimeout, 60*time.Second),
+			IdleTimeout:       timeoutOr(s.IdleTimeout, 120*time.Second),
+			BaseContext: func(net.Listener) context.Context {
+				return context.WithValue(context.Background(), serverKey{}, s)
+			},
+		}
+	}
+	return s.srv
+}
+
+// track is called by Handler.ServeHTTP, returned function must be called when
+// the request is done
+func track(r *http.Request) func() {
+	s, ok := r.Context().Value(serverKey{}).(*Server)
+	if !ok {
+		return func() {}
+	}
+	s.inFlight.Add(1)
+	return func() { s.inFlight.Add(-1) }
+}
+
+// InFlight returns number of jsonapi handlers being executed
+func (s *Server) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// OnShutdown registers a hook, which is called after in-flight requests are
+// drained. Hooks are called in registration order, even if some of them
+// fail.
+func (s *Server) OnShutdown(f func(ctx context.Context) error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.hooks = append(s.hooks, f)
+}
+
+// Listen creates the listener according to Addr
+func (s *Server) Listen() (net.Listener, error) {
+	addr := s.Addr
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		p := strings.TrimPrefix(addr, "unix:")
+		if info, err := os.Stat(p); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(p)
+		}
+		return net.Listen("unix", p)
+	case addr == "systemd":
+		return systemdListener("")
+	case strings.HasPrefix(addr, "systemd:"):
+		return systemdListener(strings.TrimPrefix(addr, "systemd:"))
+	case addr == "":
+		addr = ":http"
+	}
+	return net.Listen("tcp", addr)
+}
+
+// systemdListener finds the socket passed by systemd, see sd_listen_fds(3)
+func systemdListener(name string) (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, errors.New("jsonapi: not activated by systemd")
+	}
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for idx := 0; idx < n; idx++ {
+		if name != "" && (idx >= len(names) || names[idx] != name) {
+			continue
+		}
+		// the first passed fd is always 3
+		f := os.NewFile(uintptr(3+idx), "LISTEN_FD_"+strconv.Itoa(3+idx))
+		defer f.Close()
+		return net.FileListener(f)
+	}
+	return nil, fmt.Errorf("jsonapi: socket %q is not passed by systemd", name)
+}
+
+// Serve accepts connections on l until Shutdown is called. It returns nil
+// if stopped by Shutdown.
+func (s *Server) Serve(l net.Listener) error {
+	err := s.server().Serve(l)
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
+
+// ListenAndServe listens on Addr and serves until one of Signals is received,
+// then shuts down gracefully. Second signal kills the process immediately.
+func (s *Server) ListenAndServe() error {
+	l, err := s.Listen()
+	if err != nil {
+		return err
+	}
+
+	sigs := s.Signals
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), sigs...)
+	defer stop()
+
+	ch := make(chan error, 1)
+	go func() { ch <- s.Serve(l) }()
+	select {
+	case err = <-ch:
+		return err
+	case <-ctx.Done():
+	}
+
+	stop()
+	err = s.Shutdown(context.Background())
+	if e := <-ch; err == nil {
+		err = e
+	}
+	return err
+}
+
+// Shutdown marks the server not ready, stops accepting new requests, waits
+// in-flight requests to finish within ShutdownTimeout, and runs hooks
+// registered by OnShutdown in order.
+//
+// Hooks receive ctx, not affected by ShutdownTimeout. Returned error joins
+// errors from draining and hooks.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.SetReady != nil {
+		s.SetReady(false)
+	}
+	if s.DrainDelay > 0 {
+		select {
+		case <-time.After(s.DrainDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	srv := s.server()
+	dctx, cancel := ctx, context.CancelFunc(func() {})
+	if d := timeoutOr(s.ShutdownTimeout, 30*time.Second); d > 0 {
+		dctx, cancel = context.WithTimeout(ctx, d)
+	}
+	defer cancel()
+	errs := []error{}
+	if err := srv.Shutdown(dctx); err != nil {
+		errs = append(errs, err)
+		srv.Close()
+	}
+
+	s.lock.Lock()
+	hooks := append([]func(context.Context) error{}, s.hooks...)
+	s.lock.Unlock()
+	for _, f := range hooks {
+		if err := f(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,124 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package jsonapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServerShutdown(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	Register(mux, []API{{Pattern: "/slow", Handler: func(r Request) (interface{}, error) {
+		close(started)
+		<-release
+		return "done", nil
+	}}})
+
+	var lock sync.Mutex
+	events := []string{}
+	log := func(s string) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, s)
+	}
+	srv := &Server{
+		Addr:     "unix:" + filepath.Join(t.TempDir(), "api.sock"),
+		Handler:  mux,
+		SetReady: func(ready bool) { log("ready") },
+	}
+	srv.OnShutdown(func(context.Context) error { log("hook1"); return errors.New("hook1") })
+	srv.OnShutdown(func(context.Context) error { log("hook2"); return nil })
+
+	l, err := srv.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", strings.TrimPrefix(srv.Addr, "unix:"))
+		},
+	}}
+	type reply struct {
+		body string
+		err  error
+	}
+	replied := make(chan reply, 1)
+	go func() {
+		resp, err := client.Get("http://unix/slow")
+		if err != nil {
+			replied <- reply{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		buf, err := io.ReadAll(resp.Body)
+		replied <- reply{string(buf), err}
+	}()
+
+	<-started
+	if x := srv.InFlight(); x != 1 {
+		t.Fatalf("expected 1 in-flight request, got %d", x)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	log("release")
+	close(release)
+
+	if err = <-shutdown; err == nil || err.Error() != "hook1" {
+		t.Errorf("unexpected error from shutdown: %v", err)
+	}
+	if r := <-replied; r.err != nil || r.body != `{"data":"done"}`+"\n" {
+		t.Errorf("unexpected reply: %+v", r)
+	}
+	if err = <-served; err != nil {
+		t.Errorf("unexpected error from serve: %v", err)
+	}
+	if x := srv.InFlight(); x != 0 {
+		t.Errorf("expected no in-flight request, got %d", x)
+	}
+	if e, a := "ready release hook1 hook2", strings.Join(events, " "); e != a {
+		t.Errorf("expected events %s, got %s", e, a)
+	}
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	srv := &Server{
+		Addr: "127.0.0.1:0",
+		Handler: Handler(func(r Request) (interface{}, error) {
+			close(started)
+			<-release
+			return nil, nil
+		}),
+		ShutdownTimeout: 50 * time.Millisecond,
+	}
+
+	l, err := srv.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	go http.Get("http://" + l.Addr().String())
+	<-started
+
+	if err = srv.Shutdown(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
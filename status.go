@@ -0,0 +1,99 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package jsonapi
+
+import "net/http"
+
+// Result wraps data returned by handler with http status code and response
+// headers. Create it with Status.
+//
+// Like Error, methods of Result fork a new instance.
+type Result struct {
+	code   int
+	data   interface{}
+	header http.Header
+}
+
+// Status creates a Result to send data with status code other than 200
+//
+//	func createArticle(q jsonapi.Request) (interface{}, error) {
+//	    a, err := create(q)
+//	    if err != nil {
+//	        return nil, err
+//	    }
+//	    return jsonapi.Status(201, a).Header("Location", "/articles/"+a.ID), nil
+//	}
+//
+// data is encoded as usual, {"data": data} for example. Nothing is written to
+// response body if code is 204 or 304. Zero code means 200, and code out of
+// range 100-999 is sent as E500.
+func Status(code int, data interface{}) Result {
+	return Result{code: code, data: data}
+}
+
+// Header forks a new Result with a response header added
+func (r Result) Header(key, value string) Result {
+	h := r.header.Clone()
+	if h == nil {
+		h = http.Header{}
+	}
+	h.Add(key, value)
+	r.header = h
+	return r
+}
+
+// SetData forks a new Result with data replaced, so middleware can modify the
+// data without losing status code and headers
+func (r Result) SetData(data interface{}) Result {
+	r.data = data
+	return r
+}
+
+// Code returns the status code
+func (r Result) Code() int {
+	return r.code
+}
+
+// Data returns the wrapped data
+func (r Result) Data() interface{} {
+	return r.data
+}
+
+// Headers returns a copy of response headers
+func (r Result) Headers() http.Header {
+	return r.header.Clone()
+}
+
+// UnwrapResult extracts status code, headers and data from Result or *Result.
+// For other values, it returns 200, nil and v. Zero status code is reported as
+// 200.
+func UnwrapResult(v interface{}) (code int, header http.Header, data interface{}) {
+	switch x := v.(type) {
+	case Result:
+		code, header, data = x.code, x.header, x.data
+	case *Result:
+		if x == nil {
+			return http.StatusOK, nil, v
+		}
+		code, header, data = x.code, x.header, x.data
+	default:
+		return http.StatusOK, nil, v
+	}
+	if code == 0 {
+		code = http.StatusOK
+	}
+	return
+}
+
+// bodyAllowed reports whether response with status code can have a body
+func bodyAllowed(code int) bool {
+	switch {
+	case code >= 100 && code < 200:
+		return false
+	case code == http.StatusNoContent, code == http.StatusNotModified:
+		return false
+	}
+	return true
+}